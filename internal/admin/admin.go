@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"GRUniChat-Broadcaster/internal/connection"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// Handler 管理API处理器
+//
+// 提供以下JSON接口（均以配置的管理路径为前缀）：
+//
+//	GET  /stats                           运行统计
+//	GET  /connections                     在线连接列表
+//	GET  /connections/{id}                指定服务器ID或会话ID的连接详情
+//	POST /connections/{id}/disconnect     强制断开指定连接
+//	GET  /messages/{totalId}              消息内容、状态和各目标投递状态
+//	GET  /messages/{totalId}/moderation   消息在各群组上的内容审核记录
+//	GET  /routes                          当前生效的路由表
+type Handler struct {
+	cm     *connection.ConnectionManager
+	path   string // 挂载路径，路由在启动时注册，热重载修改admin.path不会生效
+	logger logger.Logger
+}
+
+// NewHandler 创建挂载在path下的管理API处理器
+func NewHandler(cm *connection.ConnectionManager, path string, log logger.Logger) *Handler {
+	return &Handler{
+		cm:     cm,
+		path:   path,
+		logger: log,
+	}
+}
+
+// ServeHTTP 实现http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.cm.GetConfig()
+	if !h.authorized(r, cfg.Admin.Token) {
+		h.logger.Infof("管理API认证失败: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, h.path)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "stats":
+		h.requireMethod(w, r, http.MethodGet, h.handleStats)
+	case len(parts) == 1 && parts[0] == "connections":
+		h.requireMethod(w, r, http.MethodGet, h.handleListConnections)
+	case len(parts) == 2 && parts[0] == "connections":
+		h.requireMethod(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			h.handleGetConnection(w, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "connections" && parts[2] == "disconnect":
+		h.requireMethod(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.handleDisconnect(w, r, parts[1])
+		})
+	case len(parts) == 2 && parts[0] == "messages":
+		h.requireMethod(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			h.handleGetMessage(w, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "messages" && parts[2] == "moderation":
+		h.requireMethod(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			h.handleGetModeration(w, parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "routes":
+		h.requireMethod(w, r, http.MethodGet, h.handleRoutes)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized 校验管理令牌，支持Authorization: Bearer和X-Admin-Token两种请求头
+func (h *Handler) authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	provided := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// requireMethod 检查请求方法后调用处理函数
+func (h *Handler) requireMethod(w http.ResponseWriter, r *http.Request, method string, handle http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	handle(w, r)
+}
+
+// handleStats 运行统计
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.cm.GetStats())
+}
+
+// handleListConnections 在线连接列表
+func (h *Handler) handleListConnections(w http.ResponseWriter, r *http.Request) {
+	connections := h.cm.ListConnections()
+	if connections == nil {
+		connections = []connection.ConnectionInfo{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":       len(connections),
+		"connections": connections,
+	})
+}
+
+// handleGetConnection 连接详情，id可以是服务器ID或会话ID
+func (h *Handler) handleGetConnection(w http.ResponseWriter, id string) {
+	sessions := h.cm.GetConnectionInfo(id)
+	if len(sessions) == 0 {
+		writeError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       id,
+		"sessions": sessions,
+	})
+}
+
+// handleDisconnect 强制断开连接
+func (h *Handler) handleDisconnect(w http.ResponseWriter, r *http.Request, id string) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "disconnected by admin"
+	}
+
+	count := h.cm.DisconnectClient(id, reason)
+	if count == 0 {
+		writeError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":           id,
+		"disconnected": count,
+	})
+}
+
+// handleGetMessage 按totalId查询消息及其状态
+func (h *Handler) handleGetMessage(w http.ResponseWriter, totalID string) {
+	data, err := h.cm.GetMessage(totalID)
+	if err != nil || data == nil {
+		writeError(w, http.StatusNotFound, "message not found")
+		return
+	}
+
+	var msg message.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		writeError(w, http.StatusInternalServerError, "stored message is corrupted")
+		return
+	}
+
+	status, err := h.cm.GetMessageStatus(totalID)
+	if err != nil {
+		h.logger.Debugf("查询消息状态失败 %s: %v", totalID, err)
+	}
+	deliveries, err := h.cm.GetDeliveryStatuses(totalID)
+	if err != nil {
+		h.logger.Debugf("查询投递状态失败 %s: %v", totalID, err)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"totalId":    totalID,
+		"status":     status,
+		"deliveries": deliveries,
+		"message":    msg,
+	})
+}
+
+// handleGetModeration 按totalId查询内容审核记录
+func (h *Handler) handleGetModeration(w http.ResponseWriter, totalID string) {
+	records := h.cm.GetModerationRecords(totalID)
+	if len(records) == 0 {
+		writeError(w, http.StatusNotFound, "no moderation records")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"totalId": totalID,
+		"records": records,
+	})
+}
+
+// handleRoutes 当前生效的路由表
+func (h *Handler) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.cm.GetRoutingTable())
+}
+
+// writeJSON 写出JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 写出JSON错误响应
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
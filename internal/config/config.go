@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strings"
 	"time"
@@ -11,28 +12,78 @@ import (
 
 // Config 主配置结构
 type Config struct {
-	Server   ServerConfig     `yaml:"server"`
-	Database DatabaseConfig   `yaml:"database"`
-	Rules    []BroadcastRule  `yaml:"rules,omitempty"`
-	Groups   []BroadcastGroup `yaml:"groups,omitempty"`
-	Clients  []ClientConfig   `yaml:"clients,omitempty"`
+	Server     ServerConfig       `yaml:"server"`
+	Auth       AuthConfig         `yaml:"auth"`
+	Admin      AdminConfig        `yaml:"admin"`
+	Metrics    MetricsConfig      `yaml:"metrics"`
+	RateLimit  RateLimitConfig    `yaml:"rate_limit"`
+	Middleware []MiddlewareConfig `yaml:"middleware,omitempty"`
+	Delivery   DeliveryConfig     `yaml:"delivery"`
+	Cluster    ClusterConfig      `yaml:"cluster"`
+	Database   DatabaseConfig     `yaml:"database"`
+	Rules      []BroadcastRule    `yaml:"rules,omitempty"`
+	Groups     []BroadcastGroup   `yaml:"groups,omitempty"`
+	Clients    []ClientConfig     `yaml:"clients,omitempty"`
 }
 
 // DatabaseConfig 数据库配置
 type DatabaseConfig struct {
-	Type       string      `yaml:"type"`        // 数据库类型: memory, redis, mysql, postgresql
-	Redis      RedisConfig `yaml:"redis"`       // Redis配置
-	MySQL      MySQLConfig `yaml:"mysql"`       // MySQL配置
-	PostgreSQL PgSQLConfig `yaml:"postgresql"`  // PostgreSQL配置
-	MessageTTL int         `yaml:"message_ttl"` // 消息TTL（秒）
+	Type          string              `yaml:"type"`           // 数据库类型: memory, redis, embedded_redis, mysql, postgresql, sqlite
+	Redis         RedisConfig         `yaml:"redis"`          // Redis配置
+	EmbeddedRedis EmbeddedRedisConfig `yaml:"embedded_redis"` // 内嵌Redis配置
+	MySQL         MySQLConfig         `yaml:"mysql"`          // MySQL配置
+	PostgreSQL    PgSQLConfig         `yaml:"postgresql"`     // PostgreSQL配置
+	SQLite        SQLiteConfig        `yaml:"sqlite"`         // SQLite配置
+	MessageTTL    int                 `yaml:"message_ttl"`    // 消息TTL（秒）
+	OfflineQueue  OfflineQueueConfig  `yaml:"offline_queue"`  // 离线消息队列配置
+	History       HistoryConfig       `yaml:"history"`        // 历史消息配置
+	Memory        MemoryConfig        `yaml:"memory"`         // 内存存储配置
+	Purge         PurgeConfig         `yaml:"purge"`          // SQL存储过期数据清理配置
+}
+
+// EmbeddedRedisConfig 内嵌Redis配置，由广播器启动并守护本地的redis-server
+type EmbeddedRedisConfig struct {
+	DataDir   string `yaml:"data_dir"`   // 数据目录，保存配置、RDB和AOF文件
+	Port      int    `yaml:"port"`       // 监听端口，只绑定127.0.0.1
+	MaxMemory string `yaml:"max_memory"` // 最大内存（如128mb），达到后写入失败，不会淘汰已有数据
+}
+
+// PurgeConfig SQL存储过期数据清理配置
+type PurgeConfig struct {
+	Interval  int `yaml:"interval"`   // 清理间隔（秒）
+	BatchSize int `yaml:"batch_size"` // 每次删除的最大行数，避免长时间锁表
+}
+
+// MemoryConfig 内存存储配置
+type MemoryConfig struct {
+	MaxEntries      int   `yaml:"max_entries"`      // 最多保存的消息、状态和投递记录数，-1表示不限制
+	MaxBytes        int64 `yaml:"max_bytes"`        // 以上数据最多占用的字节数（近似），-1表示不限制
+	CleanupInterval int   `yaml:"cleanup_interval"` // 清理过期数据的间隔（秒）
+}
+
+// HistoryConfig 历史消息配置
+type HistoryConfig struct {
+	Enabled      bool     `yaml:"enabled"`       // 是否记录历史消息并允许客户端请求回放
+	MessageTypes []string `yaml:"message_types"` // 记录的消息类型，默认chat和event
+	MaxEntries   int      `yaml:"max_entries"`   // 最多保留的历史消息数，超出时删除最旧的消息
+	TTL          int      `yaml:"ttl"`           // 历史消息保留时间（秒）
+	DefaultLimit int      `yaml:"default_limit"` // 请求未指定条数时回放的消息数
+	MaxLimit     int      `yaml:"max_limit"`     // 单次请求最多回放的消息数
+}
+
+// OfflineQueueConfig 离线消息队列配置
+type OfflineQueueConfig struct {
+	Enabled bool `yaml:"enabled"`  // 是否为离线的群组成员缓存消息
+	MaxSize int  `yaml:"max_size"` // 每个目标最多缓存的消息数，超出时丢弃最旧的消息
 }
 
 // RedisConfig Redis配置
 type RedisConfig struct {
-	Host     string `yaml:"host"`     // Redis主机地址
-	Port     int    `yaml:"port"`     // Redis端口
-	Password string `yaml:"password"` // Redis密码
-	DB       int    `yaml:"db"`       // Redis数据库编号
+	Host      string `yaml:"host"`       // Redis主机地址
+	Port      int    `yaml:"port"`       // Redis端口
+	Password  string `yaml:"password"`   // Redis密码
+	DB        int    `yaml:"db"`         // Redis数据库编号
+	KeyPrefix string `yaml:"key_prefix"` // 键前缀，多个实例共用一个Redis数据库时用于区分，如 "grunichat:prod:"
 }
 
 // MySQLConfig MySQL配置
@@ -54,11 +105,126 @@ type PgSQLConfig struct {
 	SSLMode  string `yaml:"sslmode"`  // SSL模式
 }
 
+// SQLiteConfig SQLite配置
+type SQLiteConfig struct {
+	Path string `yaml:"path"` // 数据库文件路径，所在目录不存在时自动创建
+}
+
 // ServerConfig 服务器配置
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port string `yaml:"port"`
-	Path string `yaml:"path"`
+	Host            string `yaml:"host"`
+	Port            string `yaml:"port"`
+	Path            string `yaml:"path"`
+	DuplicatePolicy string `yaml:"duplicate_policy,omitempty"` // 重复服务器ID处理策略: reject, kick, multi
+	PingInterval    int    `yaml:"ping_interval,omitempty"`    // 协议层ping发送间隔（秒）
+	PongTimeout     int    `yaml:"pong_timeout,omitempty"`     // 等待pong或任意消息的超时时间（秒）
+	WriteTimeout    int    `yaml:"write_timeout,omitempty"`    // 单次写入超时时间（秒）
+	CommandTimeout  int    `yaml:"command_timeout,omitempty"`  // 等待executeAt命令返回结果的超时时间（秒）
+}
+
+// 重复服务器ID处理策略
+const (
+	DuplicatePolicyReject = "reject" // 拒绝新连接
+	DuplicatePolicyKick   = "kick"   // 踢掉旧连接
+	DuplicatePolicyMulti  = "multi"  // 允许多个会话，消息发送到所有会话
+)
+
+// AuthConfig 客户端认证配置
+type AuthConfig struct {
+	Enabled      bool               `yaml:"enabled"`                  // 是否启用hello认证
+	MaxClockSkew int                `yaml:"max_clock_skew,omitempty"` // HMAC签名允许的时间偏差（秒）
+	Credentials  []ClientCredential `yaml:"credentials,omitempty"`    // 每个服务器ID的凭据
+}
+
+// ClientCredential 客户端凭据，token和secret至少配置一个
+type ClientCredential struct {
+	ServerID string `yaml:"server_id"`        // 服务器ID，对应hello消息的from字段
+	Token    string `yaml:"token,omitempty"`  // 共享令牌
+	Secret   string `yaml:"secret,omitempty"` // HMAC-SHA256签名密钥
+}
+
+// AdminConfig 管理API配置
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`        // 是否启用HTTP管理API
+	Path    string `yaml:"path"`           // 管理API路径前缀
+	Port    string `yaml:"port,omitempty"` // 独立监听端口，为空时与WebSocket共用端口
+	Token   string `yaml:"token"`          // 管理令牌，通过Authorization: Bearer或X-Admin-Token请求头传递
+}
+
+// MetricsConfig Prometheus指标配置
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"` // 是否在WebSocket端口上暴露指标
+	Path    string `yaml:"path"`    // 指标路径
+}
+
+// MiddlewareConfig 中间件链中的一项，按列表顺序执行
+type MiddlewareConfig struct {
+	Name    string                 `yaml:"name"`              // 注册的中间件名称
+	Options map[string]interface{} `yaml:"options,omitempty"` // 中间件自定义选项
+}
+
+// DecodeOptions 将选项解码到结构体，未配置的字段保持原值
+func (m MiddlewareConfig) DecodeOptions(out interface{}) error {
+	if len(m.Options) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(m.Options)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("中间件 '%s' 的选项无效: %v", m.Name, err)
+	}
+	return nil
+}
+
+// DefaultMiddleware 未配置middleware时使用的默认中间件链
+func DefaultMiddleware() []MiddlewareConfig {
+	return []MiddlewareConfig{
+		{Name: "auth"},
+		{Name: "rate_limit"},
+		{Name: "validation"},
+		{Name: "logging"},
+	}
+}
+
+// RateLimitConfig 消息限流配置，所有限制同时生效
+type RateLimitConfig struct {
+	Enabled   bool                 `yaml:"enabled"`            // 是否启用限流
+	PerServer RateLimit            `yaml:"per_server"`         // 每个服务器ID的默认限制
+	PerSender RateLimit            `yaml:"per_sender"`         // 每个服务器上每个发送者（body.sender）的限制
+	PerType   map[string]RateLimit `yaml:"per_type,omitempty"` // 每个服务器上各消息类型的限制
+	Servers   map[string]RateLimit `yaml:"servers,omitempty"`  // 按服务器ID覆盖per_server
+	Mute      MuteConfig           `yaml:"mute"`               // 重复超限自动禁言
+}
+
+// RateLimit 令牌桶参数，rate<=0表示不限制
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`  // 每秒补充的令牌数
+	Burst int     `yaml:"burst"` // 桶容量，为0时取rate向上取整
+}
+
+// MuteConfig 自动禁言配置，threshold<=0表示不自动禁言
+type MuteConfig struct {
+	Threshold int `yaml:"threshold"` // 窗口内超限次数达到该值时禁言
+	Window    int `yaml:"window"`    // 统计超限次数的窗口（秒）
+	Duration  int `yaml:"duration"`  // 禁言时长（秒）
+}
+
+// DeliveryConfig 端到端投递确认配置
+type DeliveryConfig struct {
+	Enabled    bool `yaml:"enabled"`     // 是否跟踪目标客户端的ack
+	AckTimeout int  `yaml:"ack_timeout"` // 等待目标ack的超时时间（秒）
+	MaxRetries int  `yaml:"max_retries"` // 超时未ack时的最大重发次数
+}
+
+// ClusterConfig 集群配置，多个广播器节点通过Redis发布订阅互相转发消息
+type ClusterConfig struct {
+	Enabled           bool        `yaml:"enabled"`            // 是否启用集群模式
+	NodeID            string      `yaml:"node_id"`            // 节点ID，集群内唯一，默认为主机名和端口
+	Redis             RedisConfig `yaml:"redis"`              // 集群使用的Redis，host为空时与database.redis相同
+	PresenceTTL       int         `yaml:"presence_ttl"`       // 节点上的服务器在线信息的有效期（秒）
+	HeartbeatInterval int         `yaml:"heartbeat_interval"` // 刷新在线信息的间隔（秒）
 }
 
 // BroadcastRule 广播规则
@@ -110,13 +276,36 @@ type ClientConfig struct {
 func createDefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Host: "0.0.0.0",
-			Port: "8765",
-			Path: "/ws",
+			Host:            "0.0.0.0",
+			Port:            "8765",
+			Path:            "/ws",
+			DuplicatePolicy: DuplicatePolicyKick,
+			PingInterval:    30,
+			PongTimeout:     60,
+			WriteTimeout:    10,
+			CommandTimeout:  30,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Path:    "/admin",
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+			Path:    "/metrics",
+		},
+		Middleware: DefaultMiddleware(),
+		Delivery: DeliveryConfig{
+			Enabled:    false,
+			AckTimeout: 10,
+			MaxRetries: 2,
 		},
 		Database: DatabaseConfig{
 			Type:       "memory",
 			MessageTTL: 3600,
+			OfflineQueue: OfflineQueueConfig{
+				Enabled: true,
+				MaxSize: 100,
+			},
 		},
 		Groups: []BroadcastGroup{
 			{
@@ -261,6 +450,14 @@ func (c *Config) GetServerAddr() string {
 	return c.Server.Host + ":" + c.Server.Port
 }
 
+// GetAdminAddr 获取管理API监听地址，未配置独立端口时与WebSocket地址相同
+func (c *Config) GetAdminAddr() string {
+	if c.Admin.Port == "" {
+		return c.GetServerAddr()
+	}
+	return c.Server.Host + ":" + c.Admin.Port
+}
+
 // GetWebSocketURL 获取WebSocket完整URL
 func (c *Config) GetWebSocketURL() string {
 	return "ws://" + c.GetServerAddr() + c.Server.Path
@@ -277,6 +474,101 @@ func (c *Config) Validate() error {
 	if c.Server.Path == "" {
 		c.Server.Path = "/ws"
 	}
+	switch c.Server.DuplicatePolicy {
+	case "":
+		c.Server.DuplicatePolicy = DuplicatePolicyKick
+	case DuplicatePolicyReject, DuplicatePolicyKick, DuplicatePolicyMulti:
+	default:
+		return fmt.Errorf("不支持的重复连接策略: %s", c.Server.DuplicatePolicy)
+	}
+
+	// 心跳配置默认值
+	if c.Server.PingInterval <= 0 {
+		c.Server.PingInterval = 30
+	}
+	if c.Server.PongTimeout <= 0 {
+		c.Server.PongTimeout = 60
+	}
+	if c.Server.WriteTimeout <= 0 {
+		c.Server.WriteTimeout = 10
+	}
+	if c.Server.CommandTimeout <= 0 {
+		c.Server.CommandTimeout = 30
+	}
+	if c.Server.PongTimeout <= c.Server.PingInterval {
+		return fmt.Errorf("pong_timeout(%d)必须大于ping_interval(%d)", c.Server.PongTimeout, c.Server.PingInterval)
+	}
+
+	// 认证配置
+	if c.Auth.MaxClockSkew < 0 {
+		return fmt.Errorf("认证配置的max_clock_skew不能为负数")
+	}
+	if c.Auth.MaxClockSkew == 0 {
+		c.Auth.MaxClockSkew = 300 // 默认允许5分钟偏差
+	}
+	seenCredentials := make(map[string]bool)
+	for _, cred := range c.Auth.Credentials {
+		if cred.ServerID == "" {
+			return fmt.Errorf("认证凭据缺少server_id")
+		}
+		if cred.Token == "" && cred.Secret == "" {
+			return fmt.Errorf("服务器 '%s' 的认证凭据必须配置token或secret", cred.ServerID)
+		}
+		if seenCredentials[cred.ServerID] {
+			return fmt.Errorf("服务器 '%s' 的认证凭据重复配置", cred.ServerID)
+		}
+		seenCredentials[cred.ServerID] = true
+	}
+
+	// 管理API配置
+	if c.Admin.Path == "" {
+		c.Admin.Path = "/admin"
+	}
+	c.Admin.Path = "/" + strings.Trim(c.Admin.Path, "/")
+	if c.Admin.Enabled {
+		if c.Admin.Token == "" {
+			return fmt.Errorf("启用管理API时必须配置admin.token")
+		}
+		if (c.Admin.Port == "" || c.Admin.Port == c.Server.Port) && c.Admin.Path == c.Server.Path {
+			return fmt.Errorf("管理API路径不能与WebSocket路径相同: %s", c.Admin.Path)
+		}
+	}
+
+	// 指标配置
+	if c.Metrics.Path == "" {
+		c.Metrics.Path = "/metrics"
+	}
+	if c.Metrics.Enabled && c.Metrics.Path == c.Server.Path {
+		return fmt.Errorf("指标路径不能与WebSocket路径相同: %s", c.Metrics.Path)
+	}
+
+	// 限流配置
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+
+	// 中间件链配置
+	if len(c.Middleware) == 0 {
+		c.Middleware = DefaultMiddleware()
+	}
+	seenMiddleware := make(map[string]bool)
+	for _, mw := range c.Middleware {
+		if mw.Name == "" {
+			return fmt.Errorf("中间件配置缺少name")
+		}
+		if seenMiddleware[mw.Name] {
+			return fmt.Errorf("中间件 '%s' 重复配置", mw.Name)
+		}
+		seenMiddleware[mw.Name] = true
+	}
+
+	// 投递确认配置默认值
+	if c.Delivery.AckTimeout <= 0 {
+		c.Delivery.AckTimeout = 10
+	}
+	if c.Delivery.MaxRetries < 0 {
+		c.Delivery.MaxRetries = 0
+	}
 
 	// 数据库配置默认值
 	if c.Database.Type == "" {
@@ -291,6 +583,20 @@ func (c *Config) Validate() error {
 		c.Database.Redis.Host = "localhost"
 	}
 
+	// 内嵌Redis配置默认值
+	if c.Database.EmbeddedRedis.DataDir == "" {
+		c.Database.EmbeddedRedis.DataDir = "data/redis"
+	}
+	if c.Database.EmbeddedRedis.Port == 0 {
+		c.Database.EmbeddedRedis.Port = 6380 // 避免与本机已有的Redis冲突
+	}
+	if c.Database.EmbeddedRedis.MaxMemory == "" {
+		c.Database.EmbeddedRedis.MaxMemory = "128mb"
+	}
+	if !validMemorySize(c.Database.EmbeddedRedis.MaxMemory) {
+		return fmt.Errorf("database.embedded_redis.max_memory格式无效: %s（应为数字加可选单位b/kb/mb/gb，如128mb）", c.Database.EmbeddedRedis.MaxMemory)
+	}
+
 	// MySQL配置默认值
 	if c.Database.MySQL.Port == 0 {
 		c.Database.MySQL.Port = 3306
@@ -310,10 +616,163 @@ func (c *Config) Validate() error {
 		c.Database.PostgreSQL.SSLMode = "disable"
 	}
 
+	// SQLite配置默认值
+	if c.Database.SQLite.Path == "" {
+		c.Database.SQLite.Path = "data/grunichat.db"
+	}
+
 	// 消息TTL默认值
 	if c.Database.MessageTTL == 0 {
 		c.Database.MessageTTL = 3600 // 默认1小时
 	}
 
+	// 离线队列默认值
+	if c.Database.OfflineQueue.MaxSize <= 0 {
+		c.Database.OfflineQueue.MaxSize = 100
+	}
+
+	// 内存存储默认值，超出上限时淘汰最久未使用的数据
+	if c.Database.Memory.MaxEntries == 0 {
+		c.Database.Memory.MaxEntries = 100000
+	}
+	if c.Database.Memory.MaxBytes == 0 {
+		c.Database.Memory.MaxBytes = 64 << 20 // 64MB
+	}
+	if c.Database.Memory.CleanupInterval <= 0 {
+		c.Database.Memory.CleanupInterval = 60
+	}
+
+	// 过期数据清理默认值
+	if c.Database.Purge.Interval <= 0 {
+		c.Database.Purge.Interval = 300
+	}
+	if c.Database.Purge.BatchSize <= 0 {
+		c.Database.Purge.BatchSize = 1000
+	}
+
+	// 历史消息默认值
+	history := &c.Database.History
+	if len(history.MessageTypes) == 0 {
+		history.MessageTypes = []string{"chat", "event"}
+	}
+	if history.MaxEntries <= 0 {
+		history.MaxEntries = 1000
+	}
+	if history.TTL <= 0 {
+		history.TTL = 86400 // 默认1天
+	}
+	if history.MaxLimit <= 0 {
+		history.MaxLimit = 200
+	}
+	if history.MaxLimit > 200 {
+		// 回放的消息一次性写入连接的发送通道，不能超过通道容量
+		return fmt.Errorf("database.history.max_limit不能超过200")
+	}
+	if history.DefaultLimit <= 0 {
+		history.DefaultLimit = 50
+	}
+	if history.DefaultLimit > history.MaxLimit {
+		history.DefaultLimit = history.MaxLimit
+	}
+
+	// 集群配置
+	if c.Cluster.Enabled {
+		if err := c.Cluster.validate(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validMemorySize 检查Redis内存大小格式，如 134217728、128mb、1gb
+func validMemorySize(size string) bool {
+	size = strings.ToLower(size)
+	for _, unit := range []string{"gb", "mb", "kb", "b"} {
+		if strings.HasSuffix(size, unit) {
+			size = strings.TrimSuffix(size, unit)
+			break
+		}
+	}
+	if size == "" {
+		return false
+	}
+	for _, r := range size {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validate 检查集群配置并补充默认值，需在数据库配置补充默认值之后调用
+func (c *ClusterConfig) validate(cfg *Config) error {
+	if c.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("获取主机名失败，请配置cluster.node_id: %v", err)
+		}
+		c.NodeID = hostname + ":" + cfg.Server.Port
+	}
+	if strings.Contains(c.NodeID, "/") {
+		return fmt.Errorf("cluster.node_id不能包含'/': %s", c.NodeID)
+	}
+	if c.Redis.Host == "" {
+		c.Redis = cfg.Database.Redis
+	}
+	if c.Redis.Port == 0 {
+		c.Redis.Port = 6379
+	}
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = 10
+	}
+	if c.PresenceTTL <= 0 {
+		c.PresenceTTL = 30
+	}
+	if c.PresenceTTL <= c.HeartbeatInterval {
+		return fmt.Errorf("cluster.presence_ttl(%d)必须大于heartbeat_interval(%d)", c.PresenceTTL, c.HeartbeatInterval)
+	}
+	return nil
+}
+
+// validate 检查限流配置并补充默认值
+func (c *RateLimitConfig) validate() error {
+	var err error
+	if c.PerServer, err = c.PerServer.normalize("per_server"); err != nil {
+		return err
+	}
+	if c.PerSender, err = c.PerSender.normalize("per_sender"); err != nil {
+		return err
+	}
+	for msgType, limit := range c.PerType {
+		if c.PerType[msgType], err = limit.normalize("per_type." + msgType); err != nil {
+			return err
+		}
+	}
+	for serverID, limit := range c.Servers {
+		if c.Servers[serverID], err = limit.normalize("servers." + serverID); err != nil {
+			return err
+		}
+	}
+
+	if c.Mute.Threshold > 0 {
+		if c.Mute.Window <= 0 {
+			c.Mute.Window = 60
+		}
+		if c.Mute.Duration <= 0 {
+			c.Mute.Duration = 300
+		}
+	}
 	return nil
 }
+
+// normalize 检查令牌桶参数，未配置burst时取rate向上取整
+func (l RateLimit) normalize(name string) (RateLimit, error) {
+	if l.Rate < 0 || l.Burst < 0 {
+		return l, fmt.Errorf("限流配置 %s 的rate和burst不能为负数", name)
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	return l, nil
+}
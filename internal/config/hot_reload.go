@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
 )
 
 // HotReloader 配置热重载管理器
@@ -146,6 +147,7 @@ func (hr *HotReloader) handleInteractiveReload() {
 		case "y", "yes":
 			hr.performReload()
 		case "n", "no":
+			metrics.HotReloads.WithLabelValues(metrics.ReloadCancelled).Inc()
 			fmt.Println("[取消] 重载已取消，恢复路由处理")
 			hr.ResumeRouting()
 		case "p", "preview":
@@ -215,6 +217,7 @@ func (hr *HotReloader) performReload() {
 	// 加载新配置
 	newConfig, err := Load(hr.configPath)
 	if err != nil {
+		metrics.HotReloads.WithLabelValues(metrics.ReloadLoadError).Inc()
 		hr.logger.Errorf("重载失败 - 配置文件加载错误: %v", err)
 		fmt.Printf("[错误] 重载失败: %v\n", err)
 		fmt.Println("[恢复] 恢复路由处理，继续使用旧配置")
@@ -224,6 +227,7 @@ func (hr *HotReloader) performReload() {
 
 	// 验证新配置
 	if err := newConfig.Validate(); err != nil {
+		metrics.HotReloads.WithLabelValues(metrics.ReloadInvalid).Inc()
 		hr.logger.Errorf("重载失败 - 配置验证错误: %v", err)
 		fmt.Printf("[错误] 重载失败: %v\n", err)
 		fmt.Println("[恢复] 恢复路由处理，继续使用旧配置")
@@ -234,6 +238,7 @@ func (hr *HotReloader) performReload() {
 	// 调用重载回调
 	if hr.onReload != nil {
 		if err := hr.onReload(newConfig); err != nil {
+			metrics.HotReloads.WithLabelValues(metrics.ReloadCallbackError).Inc()
 			hr.logger.Errorf("重载失败 - 回调错误: %v", err)
 			fmt.Printf("[错误] 重载失败: %v\n", err)
 			fmt.Println("[恢复] 恢复路由处理，继续使用旧配置")
@@ -250,6 +255,7 @@ func (hr *HotReloader) performReload() {
 	// 恢复路由处理
 	hr.ResumeRouting()
 
+	metrics.HotReloads.WithLabelValues(metrics.ReloadSuccess).Inc()
 	hr.logger.Info("配置重载成功")
 	if hr.isInteractive {
 		fmt.Println("[成功] 配置重载成功！路由处理已恢复")
@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+)
+
+// Authenticator hello握手认证器
+type Authenticator struct {
+	enabled      bool
+	maxClockSkew time.Duration
+	credentials  map[string]config.ClientCredential
+	seenNonces   map[string]map[string]time.Time // 每个服务器ID已使用的nonce及其过期时间
+	mu           sync.Mutex
+}
+
+// NewAuthenticator 根据配置创建认证器
+func NewAuthenticator(cfg *config.AuthConfig) *Authenticator {
+	credentials := make(map[string]config.ClientCredential, len(cfg.Credentials))
+	for _, cred := range cfg.Credentials {
+		credentials[cred.ServerID] = cred
+	}
+
+	return &Authenticator{
+		enabled:      cfg.Enabled,
+		maxClockSkew: time.Duration(cfg.MaxClockSkew) * time.Second,
+		credentials:  credentials,
+		seenNonces:   make(map[string]map[string]time.Time),
+	}
+}
+
+// AdoptNonces 接管旧认证器已使用的nonce，热重载后仍可拒绝重放的签名
+func (a *Authenticator) AdoptNonces(old *Authenticator) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for from, nonces := range old.seenNonces {
+		a.seenNonces[from] = nonces
+	}
+}
+
+// Authenticate 校验hello消息携带的凭据
+//
+// 客户端可以在body中携带token，或者携带nonce（Unix时间戳）和
+// signature = hex(HMAC-SHA256(secret, from + ":" + nonce))。
+func (a *Authenticator) Authenticate(msg *message.Message) error {
+	if !a.enabled {
+		return nil
+	}
+
+	cred, exists := a.credentials[msg.From]
+	if !exists {
+		return fmt.Errorf("服务器 '%s' 未配置认证凭据", msg.From)
+	}
+
+	if cred.Token != "" && msg.Body.Token != "" {
+		if subtle.ConstantTimeCompare([]byte(cred.Token), []byte(msg.Body.Token)) == 1 {
+			return nil
+		}
+		return fmt.Errorf("令牌无效")
+	}
+
+	if cred.Secret != "" && msg.Body.Signature != "" {
+		return a.verifySignature(cred.Secret, msg)
+	}
+
+	return fmt.Errorf("缺少认证凭据")
+}
+
+// verifySignature 校验HMAC签名及时间戳
+func (a *Authenticator) verifySignature(secret string, msg *message.Message) error {
+	nonce, err := strconv.ParseInt(msg.Body.Nonce, 10, 64)
+	if err != nil {
+		return fmt.Errorf("nonce格式无效")
+	}
+
+	issuedAt := time.Unix(nonce, 0)
+	skew := time.Since(issuedAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > a.maxClockSkew {
+		return fmt.Errorf("签名已过期")
+	}
+
+	expected := SignHello(secret, msg.From, msg.Body.Nonce)
+	if !hmac.Equal([]byte(expected), []byte(msg.Body.Signature)) {
+		return fmt.Errorf("签名无效")
+	}
+
+	// nonce在时间偏差窗口内只能使用一次，窗口过后签名本身已过期
+	if !a.useNonce(msg.From, msg.Body.Nonce, issuedAt.Add(a.maxClockSkew)) {
+		return fmt.Errorf("签名已被使用")
+	}
+	return nil
+}
+
+// useNonce 记录服务器ID使用的nonce，nonce已被使用时返回false，同时清理已过期的记录
+func (a *Authenticator) useNonce(from, nonce string, expiresAt time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	nonces := a.seenNonces[from]
+	if nonces == nil {
+		nonces = make(map[string]time.Time)
+		a.seenNonces[from] = nonces
+	}
+	for seen, expiry := range nonces {
+		if now.After(expiry) {
+			delete(nonces, seen)
+		}
+	}
+
+	if _, used := nonces[nonce]; used {
+		return false
+	}
+	nonces[nonce] = expiresAt
+	return true
+}
+
+// SignHello 计算hello消息的HMAC签名
+func SignHello(secret, serverID, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(serverID + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
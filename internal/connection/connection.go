@@ -2,8 +2,12 @@ package connection
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,35 +15,87 @@ import (
 	"GRUniChat-Broadcaster/internal/config"
 	"GRUniChat-Broadcaster/internal/message"
 	"GRUniChat-Broadcaster/pkg/broadcaster"
+	"GRUniChat-Broadcaster/pkg/cluster"
 	"GRUniChat-Broadcaster/pkg/database"
 	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
 	"GRUniChat-Broadcaster/pkg/middleware"
 	"GRUniChat-Broadcaster/pkg/router"
+	"GRUniChat-Broadcaster/pkg/utils"
 )
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // 允许所有来源，生产环境中应该更严格
 	},
+	EnableCompression: true, // 是否实际压缩由hello协商的compression能力决定
 }
 
 // WSConnection WebSocket连接实现
 type WSConnection struct {
 	ws              *websocket.Conn
 	serverID        string
+	sessionID       string
 	send            chan []byte
 	isAuthenticated bool
+	heartbeat       Heartbeat
 	logger          logger.Logger
+	remoteAddr      string
+	format          string   // hello握手时协商的消息格式
+	protocolVersion int      // hello握手时协商的协议版本
+	capabilities    []string // hello握手时协商的能力
+	connectedAt     time.Time
+	authenticatedAt time.Time
+	closed          bool
+	closeCode       int
+	closeReason     string
+	mu              sync.RWMutex
 }
 
 // NewWSConnection 创建新的WebSocket连接
-func NewWSConnection(ws *websocket.Conn, log logger.Logger) *WSConnection {
+func NewWSConnection(ws *websocket.Conn, heartbeat Heartbeat, log logger.Logger) *WSConnection {
 	return &WSConnection{
 		ws:              ws,
 		serverID:        "",
+		sessionID:       message.GenerateMessageID(),
 		send:            make(chan []byte, 256),
 		isAuthenticated: false,
+		heartbeat:       heartbeat,
 		logger:          log,
+		remoteAddr:      ws.RemoteAddr().String(),
+		format:          message.FormatLegacy,
+		connectedAt:     time.Now(),
+	}
+}
+
+// ConnectionInfo 连接详情（用于管理API）
+type ConnectionInfo struct {
+	ServerID        string    `json:"serverId"`
+	SessionID       string    `json:"sessionId"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	Format          string    `json:"format"`
+	ProtocolVersion int       `json:"protocolVersion"`
+	Capabilities    []string  `json:"capabilities"`
+	ConnectedAt     time.Time `json:"connectedAt"`
+	AuthenticatedAt time.Time `json:"authenticatedAt"`
+	PendingSend     int       `json:"pendingSend"` // 发送通道中待写出的消息数
+}
+
+// Info 获取连接详情
+func (c *WSConnection) Info() ConnectionInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return ConnectionInfo{
+		ServerID:        c.serverID,
+		SessionID:       c.sessionID,
+		RemoteAddr:      c.remoteAddr,
+		Format:          c.format,
+		ProtocolVersion: c.protocolVersion,
+		Capabilities:    c.capabilities,
+		ConnectedAt:     c.connectedAt,
+		AuthenticatedAt: c.authenticatedAt,
+		PendingSend:     len(c.send),
 	}
 }
 
@@ -48,16 +104,102 @@ func (c *WSConnection) GetID() string {
 	return c.serverID
 }
 
+// GetSessionID 实现broadcaster.Connection接口
+func (c *WSConnection) GetSessionID() string {
+	return c.sessionID
+}
+
+// Kick 实现broadcaster.Connection接口
+func (c *WSConnection) Kick(reason string) {
+	c.CloseWithReason(CloseSessionReplaced, reason)
+}
+
 // Send 实现broadcaster.Connection接口
 func (c *WSConnection) Send(data []byte) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
 	select {
 	case c.send <- data:
 		return nil
 	default:
+		metrics.SendChannelFull.WithLabelValues(c.serverID).Inc()
 		return ErrChannelFull
 	}
 }
 
+// GetFormat 实现broadcaster.Connection接口
+func (c *WSConnection) GetFormat() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.format
+}
+
+// HasCapability 实现broadcaster.Connection接口
+func (c *WSConnection) HasCapability(capability string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return utils.Contains(c.capabilities, capability)
+}
+
+// negotiate 保存hello握手的协商结果
+func (c *WSConnection) negotiate(format string, protocolVersion int, capabilities []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.format = format
+	c.protocolVersion = protocolVersion
+	c.capabilities = capabilities
+}
+
+// frameOptions 根据协商的能力返回数据帧类型和是否压缩
+func (c *WSConnection) frameOptions() (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	frameType := websocket.TextMessage
+	if utils.Contains(c.capabilities, message.CapabilityBinary) {
+		frameType = websocket.BinaryMessage
+	}
+	return frameType, utils.Contains(c.capabilities, message.CapabilityCompression)
+}
+
+// sendMessage 按指定格式序列化并发送消息
+func (c *WSConnection) sendMessage(msg *message.Message, format string) {
+	if data, err := message.Encode(msg, format); err == nil {
+		c.Send(data)
+	}
+}
+
+// sendJSON 序列化并发送回复消息
+func (c *WSConnection) sendJSON(v interface{}) {
+	if data, err := json.Marshal(v); err == nil {
+		c.Send(data)
+	}
+}
+
+// Close 关闭发送通道，writePump发送完剩余消息后会关闭WebSocket
+func (c *WSConnection) Close() {
+	c.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason 以指定的关闭码和原因关闭连接
+func (c *WSConnection) CloseWithReason(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.send)
+}
+
 // IsConnected 实现broadcaster.Connection接口
 func (c *WSConnection) IsConnected() bool {
 	return c.ws != nil && c.isAuthenticated
@@ -65,11 +207,19 @@ func (c *WSConnection) IsConnected() bool {
 
 // ConnectionManager 管理所有WebSocket连接
 type ConnectionManager struct {
-	broadcaster  *broadcaster.Broadcaster
-	config       *config.Config
-	logger       logger.Logger
-	messageStore database.MessageStoreInterface
-	messageTTL   time.Duration
+	broadcaster   *broadcaster.Broadcaster
+	authenticator *Authenticator
+	tracker       *broadcaster.DeliveryTracker
+	commands      *broadcaster.CommandTracker
+	stats         *broadcaster.MessageStats       // 跨热重载保留尚未写入存储的统计增量
+	rateLimiter   *middleware.RateLimitMiddleware // 跨热重载保留令牌桶和禁言状态
+	middlewares   *middleware.Registry            // 按名称注册的中间件，热重载时据此重建中间件链
+	config        *config.Config
+	logger        logger.Logger
+	messageStore  database.MessageStoreInterface
+	messageTTL    time.Duration
+	cluster       *cluster.Cluster // 集群节点，未启用集群时为nil，不支持热重载
+	serverVersion string           // 广播器版本，在hello确认中返回
 }
 
 // NewConnectionManager 创建新的连接管理器
@@ -77,17 +227,8 @@ func NewConnectionManager(cfg *config.Config, log logger.Logger) (*ConnectionMan
 	// 创建路由器
 	rt := router.NewRouter(cfg, log)
 
-	// 创建中间件链
-	mw := middleware.NewMiddlewareChain(log)
-	mw.Add(middleware.NewAuthMiddleware(log))
-	mw.Add(middleware.NewValidationMiddleware(log))
-	mw.Add(middleware.NewLoggingMiddleware(log))
-
-	// 创建广播器
-	bc := broadcaster.NewBroadcaster(rt, mw, cfg, log)
-
 	// 创建消息存储
-	messageStore, err := database.CreateMessageStore(&cfg.Database)
+	messageStore, err := database.CreateMessageStore(&cfg.Database, log)
 	if err != nil {
 		return nil, fmt.Errorf("创建消息存储失败: %v", err)
 	}
@@ -95,20 +236,92 @@ func NewConnectionManager(cfg *config.Config, log logger.Logger) (*ConnectionMan
 	// 获取消息TTL
 	messageTTL := database.GetMessageTTL(&cfg.Database)
 
+	// 注册内置中间件并按配置创建中间件链
+	rateLimiter := middleware.NewRateLimitMiddleware(&cfg.RateLimit, log)
+	registry := middleware.NewRegistry()
+	middleware.RegisterBuiltins(registry, middleware.Dependencies{
+		RateLimiter: rateLimiter,
+		Store:       messageStore,
+		TTL:         messageTTL,
+		Logger:      log,
+	})
+	mw, err := registry.Build(cfg.Middleware, log)
+	if err != nil {
+		messageStore.Close()
+		return nil, fmt.Errorf("创建中间件链失败: %v", err)
+	}
+
+	// 创建广播器
+	bc := broadcaster.NewBroadcaster(rt, mw, cfg, log)
+	bc.SetOutbox(newOutbox(cfg, messageStore, log))
+	bc.SetHistory(newHistory(cfg, messageStore, log))
+	stats := broadcaster.NewMessageStats(messageStore, log)
+	bc.SetMessageStats(stats)
+
 	cm := &ConnectionManager{
-		broadcaster:  bc,
-		config:       cfg,
-		logger:       log,
-		messageStore: messageStore,
-		messageTTL:   messageTTL,
+		broadcaster:   bc,
+		authenticator: NewAuthenticator(&cfg.Auth),
+		rateLimiter:   rateLimiter,
+		middlewares:   registry,
+		config:        cfg,
+		logger:        log,
+		messageStore:  messageStore,
+		messageTTL:    messageTTL,
+		stats:         stats,
+		serverVersion: "dev",
+	}
+	cm.updateDeliveryTracker(cfg)
+	bc.SetDeliveryTracker(cm.tracker)
+	cm.commands = broadcaster.NewCommandTracker(commandTimeout(cfg), messageStore, messageTTL, log)
+	bc.SetCommandTracker(cm.commands)
+
+	// 启用集群时加入集群，其他节点发来的消息由当前广播器处理
+	if cfg.Cluster.Enabled {
+		if cm.cluster, err = newCluster(cfg, log); err != nil {
+			stats.Stop()
+			messageStore.Close()
+			return nil, fmt.Errorf("创建集群节点失败: %v", err)
+		}
+		bc.SetCluster(cm.cluster)
+		if err := cm.cluster.Start(); err != nil {
+			cm.cluster.Stop()
+			stats.Stop()
+			messageStore.Close()
+			return nil, fmt.Errorf("启动集群节点失败: %v", err)
+		}
 	}
+	cm.registerMetrics()
 
 	log.Infof("消息存储初始化成功，类型: %s", cfg.Database.Type)
 	return cm, nil
 }
 
+// SetServerVersion 设置在hello确认中返回的广播器版本
+func (cm *ConnectionManager) SetServerVersion(version string) {
+	cm.serverVersion = version
+}
+
+// registerMetrics 注册连接数指标，采集时读取当前广播器（热重载后自动跟随新广播器）
+func (cm *ConnectionManager) registerMetrics() {
+	metrics.RegisterGaugeFunc("grunichat_connected_servers", "当前已认证的服务器数", func() float64 {
+		return float64(len(cm.broadcaster.GetConnections()))
+	})
+	metrics.RegisterGaugeFunc("grunichat_connected_sessions", "当前已认证的会话数", func() float64 {
+		return float64(cm.broadcaster.GetConnectionCount())
+	})
+}
+
 // Stop 停止连接管理器
 func (cm *ConnectionManager) Stop() error {
+	if cm.cluster != nil {
+		if err := cm.cluster.Stop(); err != nil {
+			cm.logger.Errorf("停止集群节点失败: %v", err)
+		}
+	}
+	// 先写入缓冲的消息统计，再关闭存储，内嵌Redis随之停止
+	if cm.stats != nil {
+		cm.stats.Stop()
+	}
 	if cm.messageStore != nil {
 		return cm.messageStore.Close()
 	}
@@ -122,30 +335,93 @@ func (cm *ConnectionManager) UpdateConfig(newConfig *config.Config) error {
 	// 创建新的路由器
 	newRouter := router.NewRouter(newConfig, cm.logger)
 
-	// 创建新的中间件链
-	mw := middleware.NewMiddlewareChain(cm.logger)
-	mw.Add(middleware.NewAuthMiddleware(cm.logger))
-	mw.Add(middleware.NewValidationMiddleware(cm.logger))
-	mw.Add(middleware.NewLoggingMiddleware(cm.logger))
+	// 按新配置重建中间件链，限流器沿用现有实例
+	mw, err := cm.middlewares.Build(newConfig.Middleware, cm.logger)
+	if err != nil {
+		return fmt.Errorf("创建中间件链失败: %v", err)
+	}
+	cm.rateLimiter.UpdateConfig(&newConfig.RateLimit)
 
-	// 创建新的广播器
+	// 创建新的广播器（数据库配置不支持热重载，沿用现有存储）
 	newBroadcaster := broadcaster.NewBroadcaster(newRouter, mw, newConfig, cm.logger)
-
-	// 迁移现有连接到新的广播器
+	newBroadcaster.SetOutbox(newOutbox(newConfig, cm.messageStore, cm.logger))
+	newBroadcaster.SetHistory(newHistory(newConfig, cm.messageStore, cm.logger))
+	newBroadcaster.SetMessageStats(cm.stats)
+	cm.updateDeliveryTracker(newConfig)
+	newBroadcaster.SetDeliveryTracker(cm.tracker)
+	cm.commands.SetTimeout(commandTimeout(newConfig))
+	newBroadcaster.SetCommandTracker(cm.commands)
+
+	// 迁移现有连接到新的广播器，集群节点先切换到新广播器，迁移时不会误报服务器下线
+	newBroadcaster.SetCluster(cm.cluster)
 	connections := cm.broadcaster.GetAllConnections()
+	newBroadcaster.AdoptConnections(connections)
 	for _, conn := range connections {
-		newBroadcaster.AddConnection(conn)
-		cm.broadcaster.RemoveConnection(conn.GetID())
+		cm.broadcaster.RemoveConnection(conn.GetSessionID())
 	}
 
-	// 更新广播器和配置
+	// 更新广播器、认证器和配置
 	cm.broadcaster = newBroadcaster
+	authenticator := NewAuthenticator(&newConfig.Auth)
+	authenticator.AdoptNonces(cm.authenticator)
+	cm.authenticator = authenticator
 	cm.config = newConfig
 
 	cm.logger.Info("连接管理器配置更新完成")
 	return nil
 }
 
+// newOutbox 根据配置创建离线消息队列，未启用时返回nil
+func newOutbox(cfg *config.Config, store database.MessageStoreInterface, log logger.Logger) *broadcaster.Outbox {
+	if !cfg.Database.OfflineQueue.Enabled {
+		return nil
+	}
+	return broadcaster.NewOutbox(store, database.GetMessageTTL(&cfg.Database), cfg.Database.OfflineQueue.MaxSize, log)
+}
+
+// newHistory 根据配置创建历史消息记录，未启用时返回nil
+func newHistory(cfg *config.Config, store database.MessageStoreInterface, log logger.Logger) *broadcaster.History {
+	if !cfg.Database.History.Enabled {
+		return nil
+	}
+	return broadcaster.NewHistory(store, &cfg.Database.History, log)
+}
+
+// newCluster 根据配置创建集群节点，节点间通过Redis发布订阅转发消息
+func newCluster(cfg *config.Config, log logger.Logger) (*cluster.Cluster, error) {
+	redisCfg := cfg.Cluster.Redis
+	addr := fmt.Sprintf("%s:%d", redisCfg.Host, redisCfg.Port)
+	transport, err := cluster.NewRedisTransport(addr, redisCfg.Password, redisCfg.DB, redisCfg.KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.New(cfg.Cluster.NodeID,
+		transport,
+		time.Duration(cfg.Cluster.PresenceTTL)*time.Second,
+		time.Duration(cfg.Cluster.HeartbeatInterval)*time.Second,
+		log), nil
+}
+
+// commandTimeout 获取等待命令结果的超时时间
+func commandTimeout(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.Server.CommandTimeout) * time.Second
+}
+
+// updateDeliveryTracker 根据配置创建或更新投递确认跟踪器，跟踪中的消息在热重载后保留
+func (cm *ConnectionManager) updateDeliveryTracker(cfg *config.Config) {
+	if !cfg.Delivery.Enabled {
+		cm.tracker = nil
+		return
+	}
+	if cm.tracker != nil {
+		cm.tracker.UpdateConfig(&cfg.Delivery)
+		return
+	}
+	cm.tracker = broadcaster.NewDeliveryTracker(&cfg.Delivery, cm.messageStore, cm.messageTTL, func(serverID string, payload []byte) error {
+		return cm.broadcaster.SendTo(serverID, payload)
+	}, cm.logger)
+}
+
 // SetHotReloader 设置热重载器引用
 func (cm *ConnectionManager) SetHotReloader(hr *config.HotReloader) {
 	// 这里我们需要访问路由器，但目前路由器在broadcaster内部
@@ -161,7 +437,7 @@ func (cm *ConnectionManager) HandleWebSocket(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	conn := NewWSConnection(ws, cm.logger)
+	conn := NewWSConnection(ws, NewHeartbeat(&cm.config.Server), cm.logger)
 	go conn.writePump(cm)
 	go conn.readPump(cm)
 }
@@ -169,29 +445,35 @@ func (cm *ConnectionManager) HandleWebSocket(w http.ResponseWriter, r *http.Requ
 func (c *WSConnection) readPump(cm *ConnectionManager) {
 	defer func() {
 		if c.isAuthenticated && c.serverID != "" {
-			cm.broadcaster.RemoveConnection(c.serverID)
+			cm.broadcaster.RemoveConnection(c.sessionID)
 		}
-		c.ws.Close()
+		c.Close()
 	}()
 
+	c.startReadHeartbeat()
+
 	for {
 		_, messageBytes, err := c.ws.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.logger.Infof("连接心跳超时，断开: %s", c.serverID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				c.logger.Errorf("WebSocket错误: %v", err)
 			}
 			break
 		}
 
-		var msg message.Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
+		// 收到任何消息都说明连接存活
+		c.extendReadDeadline()
+
+		// 识别新旧两种消息格式，统一转换为内部表示
+		msg, format, err := message.Decode(messageBytes)
+		if err != nil {
 			c.logger.Errorf("解析消息失败: %v", err)
 
 			// 发送错误回复
-			errorMsg := message.NewErrorMessage("", "消息格式错误", 400)
-			if errorBytes, err := json.Marshal(errorMsg); err == nil {
-				c.Send(errorBytes)
-			}
+			c.sendJSON(message.NewErrorMessage("", "消息格式错误", 400))
 			continue
 		}
 
@@ -203,28 +485,60 @@ func (c *WSConnection) readPump(cm *ConnectionManager) {
 			c.logger.Errorf("无效消息格式: %+v", msg)
 
 			// 发送错误回复
-			errorMsg := message.NewErrorMessage(msg.TotalID, "消息格式验证失败", 400)
-			if errorBytes, err := json.Marshal(errorMsg); err == nil {
-				c.Send(errorBytes)
-			}
+			c.sendJSON(message.NewErrorMessage(msg.TotalID, "消息格式验证失败", 400))
 			continue
 		}
 
 		// 更新时间戳
 		msg.UpdateTimestamp()
 
+		// 应用层ping直接回复pong，不参与广播
+		if c.handleHeartbeatMessage(msg, format) {
+			continue
+		}
+
 		// 处理hello消息进行身份验证
-		if msg.Type == "hello" && !c.isAuthenticated {
+		if msg.Type == "hello" {
+			if c.isAuthenticated {
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, "连接已认证", 400))
+				continue
+			}
+
+			if err := cm.authenticator.Authenticate(msg); err != nil {
+				c.logger.Errorf("客户端 %s 认证失败: %v", msg.From, err)
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, "认证失败", 401))
+				c.CloseWithReason(websocket.ClosePolicyViolation, "认证失败")
+				return
+			}
+
+			protocolVersion, err := message.NegotiateProtocolVersion(msg.Body.ProtocolVersion)
+			if err != nil {
+				c.logger.Errorf("客户端 %s 协议版本协商失败: %v", msg.From, err)
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, err.Error(), 426))
+				c.CloseWithReason(websocket.CloseProtocolError, "不支持的协议版本")
+				return
+			}
+			capabilities := message.NegotiateCapabilities(msg.Body.Capabilities)
+			c.negotiate(format, protocolVersion, capabilities)
+
 			c.serverID = msg.From
 			c.isAuthenticated = true
-			cm.broadcaster.AddConnection(c)
-			c.logger.Infof("客户端 %s 已通过hello消息认证", c.serverID)
-
-			// 发送确认消息
-			ackMsg := message.NewAckMessage(msg.TotalID, "success", "认证成功")
-			if ackBytes, err := json.Marshal(ackMsg); err == nil {
-				c.Send(ackBytes)
+			c.authenticatedAt = time.Now()
+			if err := cm.broadcaster.AddConnection(c); err != nil {
+				c.logger.Errorf("客户端 %s 注册失败: %v", msg.From, err)
+				c.isAuthenticated = false
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, "服务器ID已被占用", 409))
+				c.CloseWithReason(websocket.ClosePolicyViolation, "服务器ID已被占用")
+				return
 			}
+			c.logger.Infof("客户端 %s 已通过hello消息认证 (会话 %s, 格式 %s, 协议 v%d, 能力 %v)",
+				c.serverID, c.sessionID, format, protocolVersion, capabilities)
+
+			// 发送确认消息，附带广播器版本和协商结果
+			c.sendJSON(message.NewHelloAck(msg.TotalID, cm.serverVersion, protocolVersion, capabilities, format))
+
+			// 重放离线期间缓存的消息
+			cm.broadcaster.ReplayOffline(c)
 			continue
 		}
 
@@ -233,60 +547,152 @@ func (c *WSConnection) readPump(cm *ConnectionManager) {
 			c.logger.Errorf("未认证的连接尝试发送消息: %s", msg.Type)
 
 			// 发送错误回复
-			errorMsg := message.NewErrorMessage(msg.TotalID, "未认证", 401)
-			if errorBytes, err := json.Marshal(errorMsg); err == nil {
-				c.Send(errorBytes)
+			c.sendJSON(message.NewErrorMessage(msg.TotalID, "未认证", 401))
+			continue
+		}
+
+		// 连接只能以hello时声明的身份发送消息，无论中间件链是否包含auth
+		if msg.From != c.serverID {
+			c.logger.Errorf("拒绝冒充消息: 连接身份=%s, from=%s", c.serverID, msg.From)
+			c.sendJSON(message.NewErrorMessage(msg.TotalID, "消息来源与连接身份不符", 401))
+			continue
+		}
+
+		// 请求回放历史消息，不参与广播
+		if msg.Type == "history" {
+			count, err := cm.broadcaster.ReplayHistory(c, msg.Body.History)
+			if err != nil {
+				c.logger.Errorf("回放历史消息失败: %v", err)
+				code := 500
+				switch {
+				case errors.Is(err, broadcaster.ErrHistoryDisabled):
+					code = 404
+				case errors.Is(err, broadcaster.ErrInvalidHistoryRequest):
+					code = 400
+				}
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, err.Error(), code))
+				continue
+			}
+			c.sendJSON(message.NewAckMessage(msg.TotalID, "success", fmt.Sprintf("历史消息回放完成，共 %d 条", count)))
+			continue
+		}
+
+		// 目标客户端的投递确认，不参与广播
+		if msg.Type == "ack" {
+			cm.broadcaster.HandleAck(c, msg)
+			continue
+		}
+
+		// 命令执行结果只回传给命令发起者
+		if msg.Type == "command_result" {
+			if err := cm.broadcaster.HandleCommandResult(c, msg); err != nil {
+				c.logger.Errorf("处理命令结果失败: %v", err)
+				code := 404
+				if errors.Is(err, broadcaster.ErrCommandNotTarget) {
+					code = 403
+				}
+				c.sendJSON(message.NewErrorMessage(msg.TotalID, err.Error(), code))
 			}
 			continue
 		}
 
-		// 存储消息到数据库
+		// 存储消息到数据库，状态设置为处理中
 		msgBytes, _ := json.Marshal(msg)
-		if err := cm.messageStore.StoreMessage(msg.TotalID, msgBytes, cm.messageTTL); err != nil {
+		if err := cm.messageStore.StoreMessageWithStatus(msg.TotalID, msgBytes, "processing", cm.messageTTL); err != nil {
 			c.logger.Errorf("存储消息到数据库失败: %v", err)
 		}
 
-		// 设置消息状态为处理中
-		if err := cm.messageStore.SetMessageStatus(msg.TotalID, "processing", cm.messageTTL); err != nil {
-			c.logger.Errorf("设置消息状态失败: %v", err)
+		// 广播消息
+		result, err := cm.broadcaster.Broadcast(c, msgBytes)
+		if err != nil {
+			c.replyBroadcastError(cm, msg.TotalID, err)
+			continue
 		}
 
-		// 广播消息
-		if err := cm.broadcaster.Broadcast(msgBytes); err != nil {
-			c.logger.Errorf("广播消息失败: %v", err)
+		// 启用投递确认时，最终状态由跟踪器根据目标ack和发送失败的目标更新
+		if result.Tracked {
+			reply := "消息已发送，等待目标确认"
+			if len(result.Failed) > 0 {
+				reply = fmt.Sprintf("消息已发送，%d 个目标发送失败，等待其余目标确认", len(result.Failed))
+			}
+			c.sendJSON(message.NewAckMessage(msg.TotalID, "success", reply))
+			continue
+		}
 
-			// 设置消息状态为失败
+		switch {
+		case len(result.Failed) > 0 && len(result.Sent) == 0 && len(result.Queued) == 0:
 			cm.messageStore.SetMessageStatus(msg.TotalID, "failed", cm.messageTTL)
-
-			// 发送错误回复
-			errorMsg := message.NewErrorMessage(msg.TotalID, "广播失败", 500)
-			if errorBytes, err := json.Marshal(errorMsg); err == nil {
-				c.Send(errorBytes)
-			}
-		} else {
+			c.sendJSON(message.NewErrorMessage(msg.TotalID, "所有目标发送失败", 502))
+		case len(result.Failed) > 0:
+			cm.messageStore.SetMessageStatus(msg.TotalID, "partial", cm.messageTTL)
+			c.sendJSON(message.NewAckMessage(msg.TotalID, "success", fmt.Sprintf("消息已广播，%d 个目标发送失败", len(result.Failed))))
+		default:
 			// 设置消息状态为成功
 			cm.messageStore.SetMessageStatus(msg.TotalID, "success", cm.messageTTL)
 
 			// 发送确认消息
-			ackMsg := message.NewAckMessage(msg.TotalID, "success", "消息已成功广播")
-			if ackBytes, err := json.Marshal(ackMsg); err == nil {
-				c.Send(ackBytes)
-			}
+			c.sendJSON(message.NewAckMessage(msg.TotalID, "success", "消息已成功广播"))
 		}
 	}
 }
 
+// replyBroadcastError 按中间件错误类型设置消息状态并回复发送者
+func (c *WSConnection) replyBroadcastError(cm *ConnectionManager, totalID string, err error) {
+	status, code := "failed", 500
+	reason := "广播失败"
+	switch {
+	case errors.Is(err, middleware.ErrDropped):
+		// 静默丢弃：发送者照常收到确认，只在存储中记录真实状态
+		c.logger.Debugf("消息 %s 被静默丢弃: %v", totalID, err)
+		cm.messageStore.SetMessageStatus(totalID, "dropped", cm.messageTTL)
+		c.sendJSON(message.NewAckMessage(totalID, "success", "消息已成功广播"))
+		return
+	case errors.Is(err, middleware.ErrRejected):
+		status, code, reason = "rejected", 400, err.Error()
+	case errors.Is(err, middleware.ErrUnauthorized):
+		status, code, reason = "unauthorized", 401, "消息来源与连接身份不符"
+	case errors.Is(err, middleware.ErrRateLimited):
+		status, code, reason = "rate_limited", 429, err.Error()
+	default:
+		c.logger.Errorf("广播消息失败: %v", err)
+	}
+
+	cm.messageStore.SetMessageStatus(totalID, status, cm.messageTTL)
+	c.sendJSON(message.NewErrorMessage(totalID, reason, code))
+}
+
 func (c *WSConnection) writePump(cm *ConnectionManager) {
-	defer c.ws.Close()
+	ticker := time.NewTicker(c.heartbeat.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				// Channel closed, send close message
+				c.mu.RLock()
+				closeMessage := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				c.mu.RUnlock()
+				c.writeWithDeadline(websocket.CloseMessage, closeMessage)
+				return
+			}
+			frameType, compress := c.frameOptions()
+			c.ws.EnableWriteCompression(compress)
+			if err := c.writeWithDeadline(frameType, message); err != nil {
+				c.logger.Errorf("发送消息失败: %v", err)
+				return
+			}
 
-	for message := range c.send {
-		if err := c.ws.WriteMessage(websocket.TextMessage, message); err != nil {
-			c.logger.Errorf("发送消息失败: %v", err)
-			return
+		case <-ticker.C:
+			if err := c.writePing(); err != nil {
+				c.logger.Debugf("发送心跳失败: %s, %v", c.serverID, err)
+				return
+			}
 		}
 	}
-	// Channel closed, send close message
-	c.ws.WriteMessage(websocket.CloseMessage, []byte{})
 }
 
 // GetStats 获取连接管理器统计信息
@@ -303,6 +709,71 @@ func (cm *ConnectionManager) GetStats() map[string]interface{} {
 	return stats
 }
 
+// GetConfig 获取当前生效的配置
+func (cm *ConnectionManager) GetConfig() *config.Config {
+	return cm.config
+}
+
+// ListConnections 获取所有已认证连接的详情，按服务器ID和会话ID排序
+func (cm *ConnectionManager) ListConnections() []ConnectionInfo {
+	var infos []ConnectionInfo
+	for _, conn := range cm.broadcaster.GetAllConnections() {
+		if wsConn, ok := conn.(*WSConnection); ok {
+			infos = append(infos, wsConn.Info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ServerID != infos[j].ServerID {
+			return infos[i].ServerID < infos[j].ServerID
+		}
+		return infos[i].SessionID < infos[j].SessionID
+	})
+	return infos
+}
+
+// GetConnectionInfo 按服务器ID或会话ID获取连接详情
+func (cm *ConnectionManager) GetConnectionInfo(id string) []ConnectionInfo {
+	var infos []ConnectionInfo
+	for _, info := range cm.ListConnections() {
+		if info.ServerID == id || info.SessionID == id {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// DisconnectClient 按服务器ID或会话ID强制断开连接，返回断开的会话数
+func (cm *ConnectionManager) DisconnectClient(id, reason string) int {
+	count := 0
+	for _, conn := range cm.broadcaster.GetAllConnections() {
+		if conn.GetID() != id && conn.GetSessionID() != id {
+			continue
+		}
+		cm.broadcaster.RemoveConnection(conn.GetSessionID())
+		if wsConn, ok := conn.(*WSConnection); ok {
+			wsConn.CloseWithReason(websocket.ClosePolicyViolation, reason)
+		}
+		count++
+	}
+	if count > 0 {
+		cm.logger.Infof("管理员断开连接: %s (%d 个会话)", id, count)
+	}
+	return count
+}
+
+// GetRoutingTable 获取当前生效的路由表
+func (cm *ConnectionManager) GetRoutingTable() map[string]interface{} {
+	return cm.broadcaster.GetRoutingTable()
+}
+
+// GetDeliveryStatuses 获取消息在各目标上的投递状态
+func (cm *ConnectionManager) GetDeliveryStatuses(messageID string) (map[string]string, error) {
+	if cm.messageStore == nil {
+		return nil, fmt.Errorf("消息存储未初始化")
+	}
+	return cm.messageStore.GetDeliveryStatuses(messageID)
+}
+
 // GetMessageStatus 获取消息状态
 func (cm *ConnectionManager) GetMessageStatus(messageID string) (string, error) {
 	if cm.messageStore == nil {
@@ -319,7 +790,41 @@ func (cm *ConnectionManager) GetMessage(messageID string) ([]byte, error) {
 	return cm.messageStore.GetMessage(messageID)
 }
 
+// GetModerationRecords 获取消息在各目标群组上的内容审核记录，按群组名排序
+func (cm *ConnectionManager) GetModerationRecords(totalID string) []middleware.ModerationRecord {
+	if cm.messageStore == nil {
+		return nil
+	}
+
+	stored, err := cm.messageStore.GetModerationRecords(totalID)
+	if err != nil {
+		cm.logger.Errorf("获取审核记录失败 %s: %v", totalID, err)
+		return nil
+	}
+
+	groups := make([]string, 0, len(stored))
+	for group := range stored {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	records := make([]middleware.ModerationRecord, 0, len(groups))
+	for _, group := range groups {
+		var record middleware.ModerationRecord
+		if err := json.Unmarshal(stored[group], &record); err != nil {
+			cm.logger.Errorf("解析审核记录失败 %s: %v", totalID, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// CloseSessionReplaced 旧会话被同一服务器ID的新连接替换时使用的关闭码
+const CloseSessionReplaced = 4001
+
 // 错误定义
 var (
-	ErrChannelFull = fmt.Errorf("发送通道已满")
+	ErrChannelFull      = fmt.Errorf("发送通道已满")
+	ErrConnectionClosed = fmt.Errorf("连接已关闭")
 )
@@ -0,0 +1,63 @@
+package connection
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+)
+
+// Heartbeat 连接心跳参数
+type Heartbeat struct {
+	PingInterval time.Duration // 协议层ping发送间隔
+	PongTimeout  time.Duration // 超过该时间未收到pong或任何消息即判定连接失效
+	WriteTimeout time.Duration // 单次写入超时
+}
+
+// NewHeartbeat 根据服务器配置创建心跳参数
+func NewHeartbeat(cfg *config.ServerConfig) Heartbeat {
+	return Heartbeat{
+		PingInterval: time.Duration(cfg.PingInterval) * time.Second,
+		PongTimeout:  time.Duration(cfg.PongTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+	}
+}
+
+// startReadHeartbeat 设置初始读超时，并在收到pong时延长
+func (c *WSConnection) startReadHeartbeat() {
+	c.extendReadDeadline()
+	c.ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+}
+
+// extendReadDeadline 延长读超时，半开连接会在超时后读取失败并被清理
+func (c *WSConnection) extendReadDeadline() {
+	c.ws.SetReadDeadline(time.Now().Add(c.heartbeat.PongTimeout))
+}
+
+// writeWithDeadline 在写超时内写入一帧
+func (c *WSConnection) writeWithDeadline(messageType int, data []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(c.heartbeat.WriteTimeout))
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// writePing 发送协议层ping
+func (c *WSConnection) writePing() error {
+	return c.writeWithDeadline(websocket.PingMessage, nil)
+}
+
+// handleHeartbeatMessage 处理应用层ping/pong，pong使用与ping相同的消息格式，返回true表示消息已处理且不应广播
+func (c *WSConnection) handleHeartbeatMessage(msg *message.Message, format string) bool {
+	if !msg.IsPingPong() {
+		return false
+	}
+
+	if msg.Type == "ping" {
+		c.sendMessage(message.NewPongMessage(msg.TotalID), format)
+	}
+	return true
+}
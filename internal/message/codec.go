@@ -0,0 +1,230 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 消息格式
+const (
+	FormatLegacy   = "legacy"   // 旧版本格式：from/totalId/currentTime + chatMessage/command/eventDetail
+	FormatStandard = "standard" // 新版本格式：source/timestamp + body.content
+)
+
+// timeLayout 旧版本格式及内部使用的时间格式
+const timeLayout = "2006-01-02 15:04:05"
+
+// standardMessage 新版本消息格式
+type standardMessage struct {
+	Type      string       `json:"type"`
+	Body      standardBody `json:"body"`
+	Source    string       `json:"source"`
+	Timestamp string       `json:"timestamp,omitempty"`
+	TotalID   string       `json:"totalId,omitempty"` // 与ack、投递报告关联使用
+}
+
+// standardBody 新版本消息体，content按消息类型对应旧版本的不同字段
+type standardBody struct {
+	Content   string `json:"content,omitempty"`
+	Sender    string `json:"sender,omitempty"`
+	ExecuteAt string `json:"executeAt,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+
+	History *HistoryRequest `json:"history,omitempty"`
+}
+
+// standardDeliveryReport 新版本格式的投递报告，统计结果放在body中
+type standardDeliveryReport struct {
+	Type      string               `json:"type"`
+	Body      standardReportDetail `json:"body"`
+	Source    string               `json:"source"`
+	Timestamp string               `json:"timestamp,omitempty"`
+	TotalID   string               `json:"totalId"`
+}
+
+// standardReportDetail 新版本格式投递报告的body
+type standardReportDetail struct {
+	Targets   map[string]string `json:"targets"`
+	Delivered int               `json:"delivered"`
+	Failed    int               `json:"failed"`
+	Pending   int               `json:"pending"`
+}
+
+// formatProbe 用于识别消息格式
+type formatProbe struct {
+	From   *string `json:"from"`
+	Source *string `json:"source"`
+}
+
+// DetectFormat 识别消息使用的格式，只有source而没有from的消息视为新版本格式
+func DetectFormat(data []byte) (string, error) {
+	var probe formatProbe
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", err
+	}
+	if probe.From == nil && probe.Source != nil {
+		return FormatStandard, nil
+	}
+	return FormatLegacy, nil
+}
+
+// Decode 解析任意格式的消息，统一转换为内部表示，同时返回识别出的格式
+func Decode(data []byte) (*Message, string, error) {
+	format, err := DetectFormat(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == FormatLegacy {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, "", err
+		}
+		return &msg, format, nil
+	}
+
+	var std standardMessage
+	if err := json.Unmarshal(data, &std); err != nil {
+		return nil, "", err
+	}
+	return std.toMessage(), format, nil
+}
+
+// Encode 按指定格式序列化消息
+func Encode(msg *Message, format string) ([]byte, error) {
+	switch format {
+	case FormatLegacy, "":
+		return json.Marshal(msg)
+	case FormatStandard:
+		return json.Marshal(fromMessage(msg))
+	default:
+		return nil, fmt.Errorf("不支持的消息格式: %s", format)
+	}
+}
+
+// EncodeDeliveryReport 按指定格式序列化投递报告
+func EncodeDeliveryReport(report *DeliveryReport, format string) ([]byte, error) {
+	switch format {
+	case FormatLegacy, "":
+		return json.Marshal(report)
+	case FormatStandard:
+		std := &standardDeliveryReport{
+			Type:    report.Type,
+			Source:  "broadcaster",
+			TotalID: report.TotalID,
+			Body: standardReportDetail{
+				Targets:   report.Targets,
+				Delivered: report.Delivered,
+				Failed:    report.Failed,
+				Pending:   report.Pending,
+			},
+		}
+		if t, err := time.ParseInLocation(timeLayout, report.Timestamp, time.Local); err == nil {
+			std.Timestamp = t.Format(time.RFC3339)
+		}
+		return json.Marshal(std)
+	default:
+		return nil, fmt.Errorf("不支持的消息格式: %s", format)
+	}
+}
+
+// Transcode 将内部格式（旧版本格式）的消息转换为指定格式，目标为旧版本格式时原样返回
+func Transcode(data []byte, format string) ([]byte, error) {
+	if format == FormatLegacy || format == "" {
+		return data, nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return Encode(&msg, format)
+}
+
+// IsValidFormat 检查格式名是否有效
+func IsValidFormat(format string) bool {
+	return format == FormatLegacy || format == FormatStandard
+}
+
+// toMessage 新版本格式转换为内部表示
+func (s *standardMessage) toMessage() *Message {
+	msg := &Message{
+		From:    s.Source,
+		Type:    s.Type,
+		TotalID: s.TotalID,
+		Body: Body{
+			Sender:    s.Body.Sender,
+			ExecuteAt: s.Body.ExecuteAt,
+			Error:     s.Body.Error,
+			Token:     s.Body.Token,
+			Signature: s.Body.Signature,
+			Nonce:     s.Body.Nonce,
+
+			ProtocolVersion: s.Body.ProtocolVersion,
+			Capabilities:    s.Body.Capabilities,
+
+			History: s.Body.History,
+		},
+	}
+	if t, err := time.Parse(time.RFC3339, s.Timestamp); err == nil {
+		msg.CurrentTime = t.Local().Format(timeLayout)
+	}
+	msg.Body.setContent(s.Type, s.Body.Content)
+	return msg
+}
+
+// fromMessage 内部表示转换为新版本格式
+func fromMessage(m *Message) *standardMessage {
+	std := &standardMessage{
+		Type:      m.Type,
+		Source:    m.From,
+		Timestamp: m.CurrentTime,
+		TotalID:   m.TotalID,
+		Body: standardBody{
+			Content:   m.Body.content(m.Type),
+			Sender:    m.Body.Sender,
+			ExecuteAt: m.Body.ExecuteAt,
+			Error:     m.Body.Error,
+		},
+	}
+	if t, err := time.ParseInLocation(timeLayout, m.CurrentTime, time.Local); err == nil {
+		std.Timestamp = t.Format(time.RFC3339)
+	}
+	return std
+}
+
+// content 按消息类型取出对应字段作为content
+func (b *Body) content(msgType string) string {
+	switch msgType {
+	case "chat":
+		return b.ChatMessage
+	case "command":
+		return b.Command
+	case "event":
+		return b.EventDetail
+	case "command_result":
+		return b.Result
+	}
+	return ""
+}
+
+// setContent 按消息类型将content写入对应字段
+func (b *Body) setContent(msgType, content string) {
+	switch msgType {
+	case "chat":
+		b.ChatMessage = content
+	case "command":
+		b.Command = content
+	case "event":
+		b.EventDetail = content
+	case "command_result":
+		b.Result = content
+	}
+}
@@ -0,0 +1,171 @@
+package message
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decodeJSON 将JSON解析为通用的map，便于忽略字段顺序比较
+func decodeJSON(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("无效的JSON %s: %v", data, err)
+	}
+	return v
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"from":"survival","type":"chat"}`, FormatLegacy},
+		{`{"source":"survival","type":"chat"}`, FormatStandard},
+		{`{"from":"survival","source":"web","type":"chat"}`, FormatLegacy},
+		{`{"type":"chat"}`, FormatLegacy},
+	}
+	for _, tt := range tests {
+		if got, err := DetectFormat([]byte(tt.data)); err != nil || got != tt.want {
+			t.Errorf("DetectFormat(%s) = %s, %v, 期望 %s", tt.data, got, err, tt.want)
+		}
+	}
+	if _, err := DetectFormat([]byte(`not json`)); err == nil {
+		t.Error("无效的JSON应返回错误")
+	}
+}
+
+func TestDecodeStandardContentPerType(t *testing.T) {
+	tests := []struct {
+		msgType string
+		field   func(*Message) string
+	}{
+		{"chat", func(m *Message) string { return m.Body.ChatMessage }},
+		{"command", func(m *Message) string { return m.Body.Command }},
+		{"event", func(m *Message) string { return m.Body.EventDetail }},
+		{"command_result", func(m *Message) string { return m.Body.Result }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msgType, func(t *testing.T) {
+			data := `{"type":"` + tt.msgType + `","source":"qq_bot","totalId":"m1","body":{"content":"hello","sender":"Alice"}}`
+			msg, format, err := Decode([]byte(data))
+			if err != nil || format != FormatStandard {
+				t.Fatalf("Decode = %v, %s, %v", msg, format, err)
+			}
+			if msg.From != "qq_bot" || msg.TotalID != "m1" || msg.Body.Sender != "Alice" || tt.field(msg) != "hello" || msg.Content() != "hello" {
+				t.Errorf("解析结果 = %+v", msg)
+			}
+		})
+	}
+}
+
+func TestStandardRoundTrip(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.Local).Format(time.RFC3339)
+	data := []byte(`{"type":"command","source":"web_panel","timestamp":"` + timestamp + `","totalId":"cmd-1",` +
+		`"body":{"content":"list","sender":"admin","executeAt":"survival"}}`)
+
+	msg, _, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.CurrentTime != "2024-01-15 10:30:00" {
+		t.Errorf("currentTime = %s, 期望按本地时区转换", msg.CurrentTime)
+	}
+
+	encoded, err := Encode(msg, FormatStandard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodeJSON(t, encoded), decodeJSON(t, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("往返后 = %v, 期望 %v", got, want)
+	}
+}
+
+func TestLegacyRoundTrip(t *testing.T) {
+	data := []byte(`{"from":"survival","type":"chat","totalId":"m1","currentTime":"2024-01-15 10:30:00",` +
+		`"body":{"sender":"Steve","chatMessage":"hi","command":"","eventDetail":""}}`)
+
+	msg, format, err := Decode(data)
+	if err != nil || format != FormatLegacy {
+		t.Fatalf("Decode = %v, %s, %v", msg, format, err)
+	}
+	encoded, err := Encode(msg, FormatLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodeJSON(t, encoded), decodeJSON(t, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("往返后 = %v, 期望 %v", got, want)
+	}
+}
+
+func TestLegacyToStandardAndBack(t *testing.T) {
+	legacy := &Message{From: "survival", Type: "event", TotalID: "e1", CurrentTime: "2024-01-15 10:30:00",
+		Body: Body{Sender: "Steve", EventDetail: "Steve joined the game"}}
+
+	encoded, err := Encode(legacy, FormatStandard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := decodeJSON(t, encoded)
+	body := std["body"].(map[string]interface{})
+	if std["source"] != "survival" || std["from"] != nil || body["content"] != "Steve joined the game" || body["eventDetail"] != nil {
+		t.Fatalf("新版本格式 = %v", std)
+	}
+
+	decoded, _, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, legacy) {
+		t.Errorf("转换回内部表示 = %+v, 期望 %+v", decoded, legacy)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	payload, _ := json.Marshal(&Message{From: "survival", Type: "chat", TotalID: "m1", Body: Body{Sender: "Steve", ChatMessage: "hi"}})
+
+	for _, format := range []string{FormatLegacy, ""} {
+		if got, err := Transcode(payload, format); err != nil || string(got) != string(payload) {
+			t.Errorf("Transcode(%q) = %s, %v, 期望原样返回", format, got, err)
+		}
+	}
+
+	got, err := Transcode(payload, FormatStandard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := decodeJSON(t, got)
+	if std["source"] != "survival" || std["totalId"] != "m1" || std["body"].(map[string]interface{})["content"] != "hi" {
+		t.Errorf("Transcode(standard) = %v", std)
+	}
+
+	if _, err := Transcode(payload, "xml"); err == nil {
+		t.Error("不支持的格式应返回错误")
+	}
+}
+
+func TestEncodeDeliveryReport(t *testing.T) {
+	report := NewDeliveryReport("m1", map[string]string{"qq_bot": "delivered", "survival": "failed"})
+	report.Timestamp = "2024-01-15 10:30:00"
+
+	legacy, err := EncodeDeliveryReport(report, FormatLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeJSON(t, legacy); got["delivered"] != float64(1) || got["failed"] != float64(1) || got["source"] != nil {
+		t.Errorf("旧版本格式投递报告 = %v", got)
+	}
+
+	standard, err := EncodeDeliveryReport(report, FormatStandard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeJSON(t, standard)
+	body := got["body"].(map[string]interface{})
+	wantTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.Local).Format(time.RFC3339)
+	if got["source"] != "broadcaster" || got["timestamp"] != wantTime || body["delivered"] != float64(1) || body["failed"] != float64(1) {
+		t.Errorf("新版本格式投递报告 = %v", got)
+	}
+}
@@ -25,6 +25,40 @@ type Body struct {
 	Command     string `json:"command"`
 	ExecuteAt   string `json:"executeAt,omitempty"` // 当type=command时，指定命令在哪个服务器执行
 	EventDetail string `json:"eventDetail"`
+	Result      string `json:"result,omitempty"`    // command_result的命令输出
+	Error       string `json:"error,omitempty"`     // command_result的错误信息
+	Token       string `json:"token,omitempty"`     // hello认证令牌
+	Signature   string `json:"signature,omitempty"` // hello认证HMAC签名
+	Nonce       string `json:"nonce,omitempty"`     // hello签名使用的Unix时间戳（秒）
+
+	ProtocolVersion int      `json:"protocolVersion,omitempty"` // hello声明的协议版本
+	Capabilities    []string `json:"capabilities,omitempty"`    // hello声明的客户端能力
+
+	History *HistoryRequest `json:"history,omitempty"` // history请求的查询条件
+}
+
+// HistoryRequest 客户端请求回放历史消息的条件，各条件为空时不限制
+type HistoryRequest struct {
+	Group   string `json:"group,omitempty"`   // 只回放该群组成员发送的消息
+	Source  string `json:"source,omitempty"`  // 只回放该服务器发送的消息
+	Since   string `json:"since,omitempty"`   // 只回放该时间之后的消息（2006-01-02 15:04:05 或 RFC3339）
+	AfterID string `json:"afterId,omitempty"` // 只回放该消息之后的消息
+	Limit   int    `json:"limit,omitempty"`   // 最多回放的条数
+}
+
+// ParseSince 解析since时间，为空时返回零值
+func (r *HistoryRequest) ParseSince() (time.Time, error) {
+	if r.Since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, r.Since); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(timeLayout, r.Since, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的since时间: %s", r.Since)
+	}
+	return t, nil
 }
 
 // AckMessage 消息确认结构
@@ -45,6 +79,27 @@ type ErrorMessage struct {
 	Timestamp string `json:"timestamp"` // 错误时间戳
 }
 
+// DeliveryReport 投递报告，汇总原消息在各目标上的确认情况
+type DeliveryReport struct {
+	TotalID   string            `json:"totalId"`   // 原消息TotalID
+	Type      string            `json:"type"`      // 固定为 "delivery_report"
+	Targets   map[string]string `json:"targets"`   // 目标 -> delivered/failed/pending
+	Delivered int               `json:"delivered"` // 已确认的目标数
+	Failed    int               `json:"failed"`    // 失败的目标数
+	Pending   int               `json:"pending"`   // 仍在等待（如离线队列中）的目标数
+	Timestamp string            `json:"timestamp"` // 报告时间戳
+}
+
+// Content 按消息类型取出正文，如chat消息的chatMessage
+func (m *Message) Content() string {
+	return m.Body.content(m.Type)
+}
+
+// SetContent 按消息类型写入正文
+func (m *Message) SetContent(content string) {
+	m.Body.setContent(m.Type, content)
+}
+
 // GetContent 获取消息内容摘要
 func (m *Message) GetContent() string {
 	if m.Body.ChatMessage != "" {
@@ -59,9 +114,22 @@ func (m *Message) GetContent() string {
 	return fmt.Sprintf("消息类型: %s", m.Type)
 }
 
+// Clone 复制消息
+func (m *Message) Clone() *Message {
+	clone := *m
+	if m.Body.Capabilities != nil {
+		clone.Body.Capabilities = append([]string{}, m.Body.Capabilities...)
+	}
+	if m.Body.History != nil {
+		history := *m.Body.History
+		clone.Body.History = &history
+	}
+	return &clone
+}
+
 // IsValidType 检查消息类型是否有效
 func (m *Message) IsValidType() bool {
-	validTypes := []string{"chat", "command", "event", "hello", "ping", "pong"}
+	validTypes := []string{"chat", "command", "event", "hello", "ping", "pong", "ack", "command_result", "history"}
 	for _, validType := range validTypes {
 		if m.Type == validType {
 			return true
@@ -116,6 +184,53 @@ func NewErrorMessage(totalID, error string, code int) *ErrorMessage {
 	}
 }
 
+// NewPongMessage 创建应用层pong回复，沿用ping消息的TotalID
+func NewPongMessage(totalID string) *Message {
+	msg := &Message{
+		From:    "broadcaster",
+		Type:    "pong",
+		TotalID: totalID,
+	}
+	msg.UpdateTimestamp()
+	return msg
+}
+
+// NewCommandErrorResult 创建由广播器生成的命令错误结果（如执行超时）
+func NewCommandErrorResult(totalID, executeAt, errMsg string) *Message {
+	msg := &Message{
+		From:    "broadcaster",
+		Type:    "command_result",
+		TotalID: totalID,
+		Body: Body{
+			ExecuteAt: executeAt,
+			Error:     errMsg,
+		},
+	}
+	msg.UpdateTimestamp()
+	return msg
+}
+
+// NewDeliveryReport 创建投递报告并统计各状态数量
+func NewDeliveryReport(totalID string, targets map[string]string) *DeliveryReport {
+	report := &DeliveryReport{
+		TotalID:   totalID,
+		Type:      "delivery_report",
+		Targets:   targets,
+		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for _, status := range targets {
+		switch status {
+		case "delivered":
+			report.Delivered++
+		case "failed":
+			report.Failed++
+		default:
+			report.Pending++
+		}
+	}
+	return report
+}
+
 // ParseCommand 解析命令
 func (b *Body) ParseCommand() (string, string) {
 	if b.Command == "" {
@@ -0,0 +1,87 @@
+package message
+
+import (
+	"fmt"
+	"time"
+)
+
+// 协议版本
+const (
+	ProtocolVersion    = 2 // 当前协议版本，v2起支持能力协商
+	MinProtocolVersion = 1 // 支持的最低协议版本，未声明版本的客户端视为v1
+)
+
+// 客户端能力
+const (
+	CapabilityAck           = "ack"            // 客户端收到消息后回复ack，可参与投递确认
+	CapabilityCommandResult = "command_result" // 客户端执行executeAt命令后返回command_result
+	CapabilityBinary        = "binary"         // 使用二进制帧接收消息
+	CapabilityCompression   = "compression"    // 启用permessage-deflate写压缩
+)
+
+// supportedCapabilities 广播器支持的能力，按协商结果中的顺序排列
+var supportedCapabilities = []string{
+	CapabilityAck,
+	CapabilityCommandResult,
+	CapabilityBinary,
+	CapabilityCompression,
+}
+
+// NegotiateProtocolVersion 协商协议版本，取客户端与广播器版本中较低者
+func NegotiateProtocolVersion(clientVersion int) (int, error) {
+	if clientVersion == 0 {
+		clientVersion = MinProtocolVersion
+	}
+	if clientVersion < MinProtocolVersion {
+		return 0, fmt.Errorf("不支持的协议版本: %d，最低支持 %d", clientVersion, MinProtocolVersion)
+	}
+	if clientVersion > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+	return clientVersion, nil
+}
+
+// NegotiateCapabilities 协商能力，返回客户端声明且广播器支持的能力，未知能力会被忽略
+//
+// 能力需要客户端显式声明：未声明能力列表的旧版插件不会回复ack或command_result，
+// 协商结果为空，投递确认和命令结果等待都会跳过这些客户端。
+func NegotiateCapabilities(requested []string) []string {
+	wanted := make(map[string]bool, len(requested))
+	for _, capability := range requested {
+		wanted[capability] = true
+	}
+
+	agreed := []string{}
+	for _, capability := range supportedCapabilities {
+		if wanted[capability] {
+			agreed = append(agreed, capability)
+		}
+	}
+	return agreed
+}
+
+// HelloAck hello握手确认，返回广播器版本和协商结果
+type HelloAck struct {
+	AckMessage
+	ServerVersion   string   `json:"serverVersion"`   // 广播器版本
+	ProtocolVersion int      `json:"protocolVersion"` // 协商后的协议版本
+	Capabilities    []string `json:"capabilities"`    // 协商后的能力列表
+	Format          string   `json:"format"`          // 该连接使用的消息格式
+}
+
+// NewHelloAck 创建hello握手确认消息
+func NewHelloAck(totalID, serverVersion string, protocolVersion int, capabilities []string, format string) *HelloAck {
+	return &HelloAck{
+		AckMessage: AckMessage{
+			TotalID:   totalID,
+			Type:      "ack",
+			Status:    "success",
+			Message:   "认证成功",
+			Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		},
+		ServerVersion:   serverVersion,
+		ProtocolVersion: protocolVersion,
+		Capabilities:    capabilities,
+		Format:          format,
+	}
+}
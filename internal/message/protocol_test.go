@@ -0,0 +1,37 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      []string
+	}{
+		{"未声明能力的旧版客户端", nil, []string{}},
+		{"空列表", []string{}, []string{}},
+		{"按广播器顺序返回", []string{"compression", "ack"}, []string{CapabilityAck, CapabilityCompression}},
+		{"忽略未知能力", []string{"command_result", "telepathy"}, []string{CapabilityCommandResult}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateCapabilities(tt.requested); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NegotiateCapabilities(%v) = %v, 期望 %v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	for client, want := range map[int]int{0: MinProtocolVersion, 1: 1, ProtocolVersion: ProtocolVersion, ProtocolVersion + 1: ProtocolVersion} {
+		if got, err := NegotiateProtocolVersion(client); err != nil || got != want {
+			t.Errorf("NegotiateProtocolVersion(%d) = %d, %v, 期望 %d", client, got, err, want)
+		}
+	}
+	if _, err := NegotiateProtocolVersion(-1); err == nil {
+		t.Error("低于最低版本时应返回错误")
+	}
+}
@@ -14,9 +14,11 @@ import (
 	"syscall"
 	"time"
 
+	"GRUniChat-Broadcaster/internal/admin"
 	"GRUniChat-Broadcaster/internal/config"
 	"GRUniChat-Broadcaster/internal/connection"
 	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
 )
 
 // 版本信息变量，通过编译时 -ldflags 注入
@@ -188,6 +190,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	cm.SetServerVersion(Version)
+
 	// 设置热重载器与路由器的关联
 	if hotReloader != nil {
 		cm.SetHotReloader(hotReloader)
@@ -195,11 +199,30 @@ func main() {
 
 	// 设置路由
 	http.HandleFunc(cfg.Server.Path, cm.HandleWebSocket)
+	if cfg.Metrics.Enabled {
+		http.Handle(cfg.Metrics.Path, metrics.Handler())
+	}
 
 	server := &http.Server{
 		Addr: cfg.GetServerAddr(),
 	}
 
+	// 管理API：未配置独立端口时与WebSocket共用服务器
+	var adminServer *http.Server
+	if cfg.Admin.Enabled {
+		adminHandler := admin.NewHandler(cm, cfg.Admin.Path, log)
+		if cfg.Admin.Port == "" || cfg.Admin.Port == cfg.Server.Port {
+			http.Handle(cfg.Admin.Path+"/", adminHandler)
+		} else {
+			adminMux := http.NewServeMux()
+			adminMux.Handle(cfg.Admin.Path+"/", adminHandler)
+			adminServer = &http.Server{
+				Addr:    cfg.GetAdminAddr(),
+				Handler: adminMux,
+			}
+		}
+	}
+
 	// 设置热重载回调
 	if hotReloader != nil {
 		hotReloader.SetReloadCallback(func(newConfig *config.Config) error {
@@ -215,6 +238,12 @@ func main() {
 	}
 
 	fmt.Printf(">>> 服务器启动成功: %s\n", cfg.GetWebSocketURL())
+	if cfg.Metrics.Enabled {
+		fmt.Printf(">>> 指标端点: http://%s%s\n", cfg.GetServerAddr(), cfg.Metrics.Path)
+	}
+	if cfg.Admin.Enabled {
+		fmt.Printf(">>> 管理API: http://%s%s/\n", cfg.GetAdminAddr(), cfg.Admin.Path)
+	}
 	if *hotReload {
 		fmt.Printf(">>> 配置热重载: 已启用\n")
 	}
@@ -234,6 +263,15 @@ func main() {
 		}
 	}()
 
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("管理API启动失败: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	<-quit
 	fmt.Printf("\n>>> 正在关闭服务器...\n")
 
@@ -252,6 +290,12 @@ func main() {
 		log.Errorf("停止连接管理器失败: %v", err)
 	}
 
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Errorf("管理API关闭失败: %v", err)
+		}
+	}
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Errorf("服务器关闭失败: %v", err)
 	} else {
@@ -3,31 +3,49 @@ package broadcaster
 import (
 	"GRUniChat-Broadcaster/internal/config"
 	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/cluster"
 	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
 	"GRUniChat-Broadcaster/pkg/middleware"
 	"GRUniChat-Broadcaster/pkg/router"
+	"GRUniChat-Broadcaster/pkg/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
 
 // Connection 连接接口
 type Connection interface {
-	GetID() string
+	GetID() string        // 服务器ID
+	GetSessionID() string // 会话ID，每个连接唯一
 	Send(data []byte) error
 	IsConnected() bool
+	Kick(reason string)                   // 以关闭帧断开连接
+	GetFormat() string                    // hello握手时协商的消息格式
+	HasCapability(capability string) bool // hello握手时是否协商了该能力
 }
 
+// ErrDuplicateServerID 服务器ID已有连接且策略为拒绝
+var ErrDuplicateServerID = errors.New("服务器ID已存在连接")
+
 // Broadcaster 消息广播器
 type Broadcaster struct {
-	connections map[string]Connection
+	connections map[string]Connection // 按会话ID索引
 	router      *router.Router
 	middleware  *middleware.MiddlewareChain
 	config      *config.Config
 	logger      logger.Logger
 	regexCache  map[string]*regexp.Regexp
+	outbox      *Outbox          // 离线消息队列，为nil时不缓存离线消息
+	tracker     *DeliveryTracker // 投递确认跟踪器，为nil时不跟踪ack
+	commands    *CommandTracker  // executeAt命令结果跟踪器
+	history     *History         // 历史消息记录，为nil时不记录
+	stats       *MessageStats    // 消息统计，为nil时不统计
+	cluster     *cluster.Cluster // 集群节点，为nil时只发送给本地连接
 	mu          sync.RWMutex
 }
 
@@ -43,39 +61,102 @@ func NewBroadcaster(rt *router.Router, mw *middleware.MiddlewareChain, cfg *conf
 	}
 }
 
-// AddConnection 添加连接
-func (b *Broadcaster) AddConnection(conn Connection) {
+// SetOutbox 设置离线消息队列
+func (b *Broadcaster) SetOutbox(outbox *Outbox) {
+	b.outbox = outbox
+}
+
+// SetDeliveryTracker 设置投递确认跟踪器
+func (b *Broadcaster) SetDeliveryTracker(tracker *DeliveryTracker) {
+	b.tracker = tracker
+}
+
+// SetCommandTracker 设置命令结果跟踪器
+func (b *Broadcaster) SetCommandTracker(commands *CommandTracker) {
+	b.commands = commands
+}
+
+// ReplayOffline 向刚完成认证的连接重放离线消息
+func (b *Broadcaster) ReplayOffline(conn Connection) {
+	if b.outbox == nil {
+		return
+	}
+	if _, err := b.outbox.Replay(conn); err != nil {
+		b.logger.Errorf("重放离线消息失败: %v", err)
+	}
+}
+
+// AddConnection 添加连接，按配置的策略处理重复的服务器ID
+func (b *Broadcaster) AddConnection(conn Connection) error {
+	if err := b.addConnection(conn); err != nil {
+		return err
+	}
+	b.announce()
+	return nil
+}
+
+// addConnection 在本地连接中添加连接
+func (b *Broadcaster) addConnection(conn Connection) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.connections[conn.GetID()] = conn
-	b.logger.Infof("添加连接: %s", conn.GetID())
+	existing := b.sessionsLocked(conn.GetID())
+	remote := b.cluster != nil && b.cluster.HasRemote(conn.GetID())
+	if len(existing) > 0 || remote {
+		switch b.config.Server.DuplicatePolicy {
+		case config.DuplicatePolicyReject:
+			b.logger.Errorf("拒绝重复连接: %s (已有 %d 个会话，其他节点: %v)", conn.GetID(), len(existing), remote)
+			return ErrDuplicateServerID
+		case config.DuplicatePolicyMulti:
+			b.logger.Infof("服务器 %s 新增会话，当前会话数: %d", conn.GetID(), len(existing)+1)
+		default:
+			// 其他节点上的旧连接由该节点收到上线通知后踢出
+			for _, old := range existing {
+				delete(b.connections, old.GetSessionID())
+				old.Kick("同一服务器ID的新连接已建立")
+				b.logger.Infof("踢出旧连接: %s (会话 %s)", old.GetID(), old.GetSessionID())
+			}
+		}
+	}
+
+	b.connections[conn.GetSessionID()] = conn
+	b.logger.Infof("添加连接: %s (会话 %s)", conn.GetID(), conn.GetSessionID())
+	return nil
 }
 
-// RemoveConnection 移除连接
-func (b *Broadcaster) RemoveConnection(connID string) {
+// AdoptConnections 直接接管已认证的连接，不应用重复连接策略（用于热重载时迁移连接）
+func (b *Broadcaster) AdoptConnections(conns []Connection) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, exists := b.connections[connID]; exists {
-		delete(b.connections, connID)
-		b.logger.Infof("移除连接: %s", connID)
+	for _, conn := range conns {
+		b.connections[conn.GetSessionID()] = conn
 	}
 }
 
-// GetConnections 获取所有连接ID
+// RemoveConnection 按会话ID移除连接
+func (b *Broadcaster) RemoveConnection(sessionID string) {
+	b.mu.Lock()
+	conn, exists := b.connections[sessionID]
+	if exists {
+		delete(b.connections, sessionID)
+		b.logger.Infof("移除连接: %s (会话 %s)", conn.GetID(), sessionID)
+	}
+	b.mu.Unlock()
+
+	if exists {
+		b.announce()
+	}
+}
+
+// GetConnections 获取所有已连接的服务器ID（去重并排序）
 func (b *Broadcaster) GetConnections() []string {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-
-	connections := make([]string, 0, len(b.connections))
-	for id := range b.connections {
-		connections = append(connections, id)
-	}
-	return connections
+	return b.serverIDsLocked()
 }
 
-// GetConnectionCount 获取连接数量
+// GetConnectionCount 获取会话数量
 func (b *Broadcaster) GetConnectionCount() int {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -94,34 +175,95 @@ func (b *Broadcaster) GetAllConnections() []Connection {
 	return connections
 }
 
-// Broadcast 广播消息
-func (b *Broadcaster) Broadcast(messageBytes []byte) error {
+// serverIDsLocked 返回去重排序后的服务器ID，调用方需持有锁
+func (b *Broadcaster) serverIDsLocked() []string {
+	seen := make(map[string]bool, len(b.connections))
+	servers := make([]string, 0, len(b.connections))
+	for _, conn := range b.connections {
+		if !seen[conn.GetID()] {
+			seen[conn.GetID()] = true
+			servers = append(servers, conn.GetID())
+		}
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// sessionsLocked 返回指定服务器ID的所有会话，调用方需持有锁
+func (b *Broadcaster) sessionsLocked(serverID string) []Connection {
+	var sessions []Connection
+	for _, conn := range b.connections {
+		if conn.GetID() == serverID {
+			sessions = append(sessions, conn)
+		}
+	}
+	return sessions
+}
+
+// Result 广播结果
+type Result struct {
+	Sent    []string // 已写入发送通道的目标
+	Failed  []string // 发送失败的目标
+	Queued  []string // 进入离线队列的目标
+	Skipped []string // 离线且未启用离线队列的目标
+	Tracked bool     // 是否由投递跟踪器负责后续状态和投递报告
+}
+
+// delivery 发往单个目标的消息
+type delivery struct {
+	target  router.Target
+	payload []byte
+}
+
+// Broadcast 广播消息，origin为发送该消息的连接
+func (b *Broadcaster) Broadcast(origin Connection, messageBytes []byte) (*Result, error) {
 	var msg message.Message
 	if err := json.Unmarshal(messageBytes, &msg); err != nil {
 		b.logger.Errorf("解析消息失败: %v", err)
-		return err
+		return nil, err
 	}
 
 	// 通过中间件处理消息
-	processedMsg, err := b.middleware.Process(&msg)
-	if err != nil {
-		b.logger.Errorf("中间件处理失败: %v", err)
-		return err
+	ctx := &middleware.Context{}
+	if origin != nil {
+		ctx.ServerID = origin.GetID()
+	}
+	source := msg.From
+	if origin != nil {
+		source = origin.GetID()
+	}
+	metrics.MessagesReceived.WithLabelValues(source, msg.Type).Inc()
+
+	// 从其他集群节点收到的消息已在来源节点广播过，再次进入（如客户端回显）时丢弃，避免在节点间循环
+	if b.cluster != nil && b.cluster.IsRemoteMessage(msg.TotalID) {
+		metrics.MessagesDropped.WithLabelValues(source, msg.Type, "", metrics.DropLoop).Inc()
+		return nil, middleware.Drop("消息 %s 已由其他节点广播", msg.TotalID)
 	}
 
-	if processedMsg == nil {
-		b.logger.Debug("消息被中间件过滤")
-		return nil
+	processedMsg, err := b.middleware.Process(ctx, &msg)
+	if err == nil && processedMsg == nil {
+		err = middleware.Drop("消息被中间件过滤")
+	}
+	if err != nil {
+		b.logger.Debugf("消息未通过中间件: %v", err)
+		metrics.MessagesDropped.WithLabelValues(source, msg.Type, "", metrics.DropMiddleware).Inc()
+		return nil, err
 	}
 
 	b.logger.Infof("广播消息: from=%s, type=%s", processedMsg.From, processedMsg.Type)
+	if b.stats != nil {
+		b.stats.Record(source, processedMsg.Type)
+	}
 
-	// 获取已连接的服务器列表
-	connectedServers := b.GetConnections()
+	// 获取已连接的服务器列表（包括其他集群节点上的服务器）
+	connectedServers := b.onlineServers()
 
 	// 获取目标服务器
-	targets := b.router.GetTargets(processedMsg.From, connectedServers)
-	b.logger.Debugf("路由目标服务器: %v", targets)
+	targets := b.router.GetTargets(processedMsg, connectedServers)
+	b.logger.Debugf("路由目标服务器: %v", router.TargetIDs(targets))
+	if len(targets) == 0 && processedMsg.Body.ExecuteAt == "" {
+		metrics.MessagesDropped.WithLabelValues(processedMsg.From, processedMsg.Type, "", metrics.DropNoRoute).Inc()
+	}
 
 	// 检查是否为指定服务器执行的命令
 	if processedMsg.Type == "command" && processedMsg.Body.ExecuteAt != "" {
@@ -136,77 +278,385 @@ func (b *Broadcaster) Broadcast(messageBytes []byte) error {
 		}
 
 		if found {
-			targets = []string{executeAtServer}
+			// 沿用路由结果中该服务器的转换规则（如果有）
+			target, ok := router.FindTarget(targets, executeAtServer)
+			if !ok {
+				target = router.Target{ServerID: executeAtServer}
+			}
+			targets = []router.Target{target}
 			b.logger.Infof("命令指定在服务器 '%s' 执行", executeAtServer)
 		} else {
 			b.logger.Errorf("指定的服务器 '%s' 未连接，命令无法执行", executeAtServer)
-			return fmt.Errorf("指定的服务器 '%s' 未连接", executeAtServer)
+			metrics.MessagesDropped.WithLabelValues(processedMsg.From, processedMsg.Type, executeAtServer, metrics.DropOffline).Inc()
+			return nil, fmt.Errorf("指定的服务器 '%s' 未连接", executeAtServer)
 		}
 	}
 
-	b.logger.Debugf("最终目标服务器: %v", targets)
+	b.logger.Debugf("最终目标服务器: %v", router.TargetIDs(targets))
 
 	// 应用组级别的黑名单过滤
 	filteredTargets := b.applyGroupBlacklist(processedMsg, targets)
 	if len(filteredTargets) != len(targets) {
-		b.logger.Debugf("黑名单过滤后的目标服务器: %v", filteredTargets)
+		b.logger.Debugf("黑名单过滤后的目标服务器: %v", router.TargetIDs(filteredTargets))
+	}
+
+	// 生成各目标的消息内容
+	deliveries, result := b.prepareDeliveries(ctx, processedMsg, messageBytes, filteredTargets)
+
+	// 先登记投递跟踪，避免目标的ack早于登记到达；不支持ack的目标不参与跟踪
+	if b.tracker != nil && origin != nil {
+		if tracked := b.filterByCapability(deliveries, message.CapabilityAck); len(tracked) > 0 {
+			b.tracker.Track(origin, processedMsg.TotalID, tracked)
+			result.Tracked = true
+		}
+	}
+
+	// 定向命令需要等待执行结果，同样先登记再发送
+	awaitResult := b.commands != nil && origin != nil &&
+		processedMsg.Type == "command" && processedMsg.Body.ExecuteAt != "" && len(deliveries) > 0 &&
+		b.targetSupports(processedMsg.Body.ExecuteAt, message.CapabilityCommandResult)
+	if awaitResult {
+		b.commands.Register(origin, processedMsg.TotalID, processedMsg.Body.ExecuteAt)
 	}
 
 	// 发送消息
-	return b.sendToTargets(messageBytes, filteredTargets)
+	b.sendToTargets(processedMsg, deliveries, result)
+	b.recordHistory(processedMsg)
+
+	if awaitResult && len(result.Sent) == 0 {
+		b.commands.Cancel(processedMsg.TotalID)
+	}
+
+	if result.Tracked {
+		b.tracker.Settle(processedMsg.TotalID, result)
+	}
+	return result, nil
 }
 
-// sendToTargets 发送消息到目标服务器
-func (b *Broadcaster) sendToTargets(messageBytes []byte, targets []string) error {
+// targetSupports 检查目标服务器是否协商了指定能力，任一会话支持即可；
+// 连接在其他集群节点上的目标按登记的能力判断，离线目标视为支持
+func (b *Broadcaster) targetSupports(serverID, capability string) bool {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	successCount := 0
+	sessions := b.sessionsLocked(serverID)
+	if len(sessions) == 0 {
+		if b.cluster != nil && b.cluster.HasRemote(serverID) {
+			return b.cluster.RemoteSupports(serverID, capability)
+		}
+		return true
+	}
+	for _, conn := range sessions {
+		if conn.HasCapability(capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByCapability 筛选出目标支持指定能力的投递
+func (b *Broadcaster) filterByCapability(deliveries []delivery, capability string) []delivery {
+	filtered := make([]delivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		if b.targetSupports(d.target.ServerID, capability) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// prepareDeliveries 按目标群组执行路由后中间件（如内容审核），再根据目标的转换规则生成待发送的消息
+func (b *Broadcaster) prepareDeliveries(ctx *middleware.Context, msg *message.Message, messageBytes []byte, targets []router.Target) ([]delivery, *Result) {
+	result := &Result{}
+	deliveries := make([]delivery, 0, len(targets))
+
+	// 同一群组只处理一次，同一消息和转换规则只序列化一次
+	type payloadKey struct {
+		msg       *message.Message
+		transform *config.Transform
+	}
+	groupMsgs := make(map[string]*message.Message)
+	msgBytes := map[*message.Message][]byte{msg: messageBytes}
+	payloads := make(map[payloadKey][]byte)
+
 	for _, target := range targets {
-		conn, exists := b.connections[target]
-		if !exists {
-			b.logger.Debugf("目标连接不存在: %s", target)
+		groupName := ""
+		if group := b.targetGroup(target); group != nil {
+			groupName = group.Name
+		}
+
+		targetMsg, ok := groupMsgs[groupName]
+		if !ok {
+			var err error
+			targetMsg, err = b.middleware.ProcessTarget(ctx, msg, groupName)
+			if err != nil {
+				b.logger.Errorf("处理发往群组 '%s' 的消息失败: %v", groupName, err)
+				targetMsg = nil
+			}
+			groupMsgs[groupName] = targetMsg
+		}
+		if targetMsg == nil {
+			b.logger.Debugf("消息被内容审核拦截: from=%s to=%s, type=%s", msg.From, target.ServerID, msg.Type)
+			metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, target.ServerID, metrics.DropModeration).Inc()
 			continue
 		}
 
+		key := payloadKey{targetMsg, target.Transform}
+		payload, ok := payloads[key]
+		if !ok {
+			var err error
+			payload, err = b.buildTargetPayload(targetMsg, msgBytes, target.Transform)
+			if err != nil {
+				b.logger.Errorf("生成发往 %s 的消息失败: %v", target.ServerID, err)
+				metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, target.ServerID, metrics.DropSendFailed).Inc()
+				result.Failed = append(result.Failed, target.ServerID)
+				continue
+			}
+			payloads[key] = payload
+		}
+		deliveries = append(deliveries, delivery{target: target, payload: payload})
+	}
+
+	return deliveries, result
+}
+
+// buildTargetPayload 为路由后中间件产生的消息生成发往目标的内容，被修改过的消息需要重新序列化
+func (b *Broadcaster) buildTargetPayload(msg *message.Message, msgBytes map[*message.Message][]byte, transform *config.Transform) ([]byte, error) {
+	data, ok := msgBytes[msg]
+	if !ok {
+		var err error
+		if data, err = json.Marshal(msg); err != nil {
+			return nil, err
+		}
+		msgBytes[msg] = data
+	}
+	return b.buildPayload(msg, data, transform)
+}
+
+// sendToTargets 发送消息到目标服务器，并将每个目标的结果记录到result
+func (b *Broadcaster) sendToTargets(msg *message.Message, deliveries []delivery, result *Result) {
+	remote, shared := b.sendToLocal(msg, deliveries, result)
+
+	// 转发到其他节点在释放锁之后进行，避免网络请求阻塞连接的增删
+	if b.cluster != nil {
+		b.sendToCluster(msg, remote, result)
+		for _, d := range shared {
+			b.cluster.Deliver(d.target.ServerID, msg.TotalID, d.payload)
+		}
+	}
+
+	b.logger.Infof("消息发送完成: %d/%d 成功, %d 条进入离线队列",
+		len(result.Sent), len(deliveries), len(result.Queued))
+}
+
+// sendToLocal 发送到本地连接，返回本地没有连接但其他节点上有的目标，
+// 以及本地和其他节点上都有会话的目标（multi策略）
+func (b *Broadcaster) sendToLocal(msg *message.Message, deliveries []delivery, result *Result) (remote, shared []delivery) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, d := range deliveries {
+		serverID := d.target.ServerID
+
+		sessions := b.sessionsLocked(serverID)
+		if b.cluster != nil && b.cluster.HasRemote(serverID) {
+			if len(sessions) == 0 {
+				remote = append(remote, d)
+				continue
+			}
+			shared = append(shared, d)
+		}
+
+		if len(sessions) == 0 {
+			if b.outbox == nil {
+				b.logger.Debugf("目标连接不存在: %s", serverID)
+				result.Skipped = append(result.Skipped, serverID)
+				metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, serverID, metrics.DropOffline).Inc()
+				continue
+			}
+			if err := b.outbox.Enqueue(serverID, d.payload); err != nil {
+				b.logger.Errorf("缓存发往 %s 的离线消息失败: %v", serverID, err)
+				result.Failed = append(result.Failed, serverID)
+				metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, serverID, metrics.DropSendFailed).Inc()
+			} else {
+				result.Queued = append(result.Queued, serverID)
+				metrics.MessagesQueued.WithLabelValues(msg.From, msg.Type, serverID).Inc()
+			}
+			continue
+		}
+
+		if b.sendToSessionsLocked(sessions, d.payload) {
+			b.logger.Debugf("消息已发送到: %s", serverID)
+			result.Sent = append(result.Sent, serverID)
+			metrics.MessagesBroadcast.WithLabelValues(msg.From, msg.Type, serverID).Inc()
+		} else {
+			result.Failed = append(result.Failed, serverID)
+			metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, serverID, metrics.DropSendFailed).Inc()
+		}
+	}
+	return remote, shared
+}
+
+// sendToSessionsLocked 发送到服务器的所有会话，任一会话成功即视为送达，调用方需持有锁
+func (b *Broadcaster) sendToSessionsLocked(sessions []Connection, payload []byte) bool {
+	delivered := false
+	for _, conn := range sessions {
 		if !conn.IsConnected() {
-			b.logger.Debugf("目标连接已断开: %s", target)
+			b.logger.Debugf("目标连接已断开: %s (会话 %s)", conn.GetID(), conn.GetSessionID())
 			continue
 		}
 
-		if err := conn.Send(messageBytes); err != nil {
-			b.logger.Errorf("发送到 %s 失败: %v", target, err)
+		if err := sendEncoded(conn, payload); err != nil {
+			b.logger.Errorf("发送到 %s (会话 %s) 失败: %v", conn.GetID(), conn.GetSessionID(), err)
 		} else {
-			b.logger.Debugf("消息已发送到: %s", target)
-			successCount++
+			delivered = true
 		}
 	}
+	return delivered
+}
+
+// sendEncoded 将内部格式的消息按连接协商的格式重新编码后发送
+func sendEncoded(conn Connection, payload []byte) error {
+	data, err := message.Transcode(payload, conn.GetFormat())
+	if err != nil {
+		return err
+	}
+	return conn.Send(data)
+}
+
+// SendTo 直接发送消息到指定服务器的所有会话（用于重发和定向回复），本地没有连接时转发给其他集群节点
+func (b *Broadcaster) SendTo(serverID string, payload []byte) error {
+	b.mu.RLock()
+	sessions := b.sessionsLocked(serverID)
+	delivered := len(sessions) > 0 && b.sendToSessionsLocked(sessions, payload)
+	b.mu.RUnlock()
 
-	b.logger.Infof("消息发送完成: %d/%d 成功", successCount, len(targets))
+	if len(sessions) == 0 {
+		if b.cluster != nil && b.cluster.HasRemote(serverID) {
+			return b.cluster.Deliver(serverID, payloadTotalID(payload), payload)
+		}
+		return fmt.Errorf("目标连接不存在: %s", serverID)
+	}
+	if !delivered {
+		return fmt.Errorf("发送到 %s 失败", serverID)
+	}
 	return nil
 }
 
+// HandleCommandResult 将命令执行结果回传给发起命令的连接，不会广播到群组
+func (b *Broadcaster) HandleCommandResult(executor Connection, msg *message.Message) error {
+	// 命令来自其他集群节点时，结果回传给该节点
+	if b.cluster != nil {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if forwarded, err := b.cluster.ForwardToOrigin(cluster.KindCommandResult, msg.TotalID, executor.GetID(), data); forwarded {
+			return err
+		}
+	}
+	if b.commands == nil {
+		return ErrUnknownCommand
+	}
+	return b.commands.Resolve(executor, msg)
+}
+
+// HandleAck 处理目标客户端对消息的投递确认
+func (b *Broadcaster) HandleAck(conn Connection, msg *message.Message) {
+	// 消息来自其他集群节点时，ack回传给该节点的跟踪器
+	if b.cluster != nil {
+		if forwarded, _ := b.cluster.ForwardToOrigin(cluster.KindAck, msg.TotalID, conn.GetID(), nil); forwarded {
+			return
+		}
+	}
+	if b.tracker == nil {
+		return
+	}
+	b.tracker.Ack(msg.TotalID, conn.GetID())
+}
+
+// buildPayload 根据转换规则生成发往目标的消息内容
+func (b *Broadcaster) buildPayload(msg *message.Message, messageBytes []byte, transform *config.Transform) ([]byte, error) {
+	if !needsTransform(msg, transform) {
+		return messageBytes, nil
+	}
+	return json.Marshal(applyTransform(msg, transform))
+}
+
+// needsTransform 检查转换规则是否会改变该消息
+func needsTransform(msg *message.Message, transform *config.Transform) bool {
+	if transform == nil {
+		return false
+	}
+	switch {
+	case transform.ChangeFrom != "":
+		return true
+	case msg.Type == "chat" && transform.PrefixChat != "":
+		return true
+	case msg.Type == "event" && transform.PrefixEvent != "":
+		return true
+	}
+	return false
+}
+
+// applyTransform 在消息副本上应用转换规则，不修改原消息
+func applyTransform(msg *message.Message, transform *config.Transform) *message.Message {
+	transformed := msg.Clone()
+
+	switch msg.Type {
+	case "chat":
+		transformed.Body.ChatMessage = transform.PrefixChat + transformed.Body.ChatMessage
+	case "event":
+		transformed.Body.EventDetail = transform.PrefixEvent + transformed.Body.EventDetail
+	}
+
+	if transform.ChangeFrom != "" {
+		transformed.From = transform.ChangeFrom
+	}
+
+	return transformed
+}
+
 // GetStats 获取广播器统计信息
 func (b *Broadcaster) GetStats() map[string]interface{} {
 	b.mu.RLock()
-	defer b.mu.RUnlock()
-
+	servers := b.serverIDsLocked()
 	stats := map[string]interface{}{
-		"total_connections": len(b.connections),
-		"connections":       b.GetConnections(),
+		"total_connections": len(servers),
+		"total_sessions":    len(b.connections),
+		"connections":       servers,
 		"router_info":       b.router.GetRouteInfo(),
 	}
+	b.mu.RUnlock()
+
+	if b.cluster != nil {
+		stats["cluster"] = b.cluster.GetStats()
+	}
+	// 读取存储中的计数器，不持有锁
+	if b.stats != nil {
+		if counts, err := b.stats.Snapshot(); err == nil {
+			stats["message_counts"] = counts
+		} else {
+			b.logger.Errorf("获取消息统计失败: %v", err)
+		}
+	}
 
 	return stats
 }
 
+// GetRoutingTable 获取当前生效的路由表
+func (b *Broadcaster) GetRoutingTable() map[string]interface{} {
+	return b.router.GetRoutingTable(b.onlineServers())
+}
+
 // applyGroupBlacklist 应用组级别的黑名单过滤
-func (b *Broadcaster) applyGroupBlacklist(msg *message.Message, targets []string) []string {
-	filtered := make([]string, 0, len(targets))
+func (b *Broadcaster) applyGroupBlacklist(msg *message.Message, targets []router.Target) []router.Target {
+	filtered := make([]router.Target, 0, len(targets))
 
 	for _, target := range targets {
 		if b.shouldBlockMessage(msg, target) {
-			b.logger.Debugf("消息被黑名单过滤: from=%s to=%s, type=%s", msg.From, target, msg.Type)
+			b.logger.Debugf("消息被黑名单过滤: from=%s to=%s, type=%s", msg.From, target.ServerID, msg.Type)
+			metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, target.ServerID, metrics.DropBlacklist).Inc()
 			continue
 		}
 		filtered = append(filtered, target)
@@ -216,21 +666,8 @@ func (b *Broadcaster) applyGroupBlacklist(msg *message.Message, targets []string
 }
 
 // shouldBlockMessage 检查消息是否应该被阻止发送到指定目标
-func (b *Broadcaster) shouldBlockMessage(msg *message.Message, target string) bool {
-	// 查找目标服务器所属的组
-	var targetGroup *config.BroadcastGroup
-	for _, group := range b.config.Groups {
-		for _, server := range group.Members {
-			if server == target {
-				targetGroup = &group
-				break
-			}
-		}
-		if targetGroup != nil {
-			break
-		}
-	}
-
+func (b *Broadcaster) shouldBlockMessage(msg *message.Message, target router.Target) bool {
+	targetGroup := b.targetGroup(target)
 	if targetGroup == nil {
 		return false // 如果找不到组，不过滤
 	}
@@ -241,8 +678,9 @@ func (b *Broadcaster) shouldBlockMessage(msg *message.Message, target string) bo
 			continue
 		}
 
-		if b.matchesBlacklistRule(msg, &rule, target) {
+		if b.matchesBlacklistRule(msg, &rule, target.ServerID) {
 			b.logger.Debugf("消息匹配黑名单规则: %s", rule.Name)
+			metrics.BlacklistHits.WithLabelValues(rule.Name).Inc()
 			return true
 		}
 	}
@@ -250,6 +688,20 @@ func (b *Broadcaster) shouldBlockMessage(msg *message.Message, target string) bo
 	return false
 }
 
+// targetGroup 获取目标所属的群组：优先使用产生该目标的群组，规则产生的目标则查找目标服务器所属的启用群组
+func (b *Broadcaster) targetGroup(target router.Target) *config.BroadcastGroup {
+	if target.Group != nil {
+		return target.Group
+	}
+	for i := range b.config.Groups {
+		group := &b.config.Groups[i]
+		if group.Enabled && utils.Contains(group.Members, target.ServerID) {
+			return group
+		}
+	}
+	return nil
+}
+
 // matchesBlacklistRule 检查消息是否匹配黑名单规则
 func (b *Broadcaster) matchesBlacklistRule(msg *message.Message, rule *config.GroupBlacklistRule, target string) bool {
 	// 检查源服务器匹配
@@ -0,0 +1,52 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"testing"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/middleware"
+	"GRUniChat-Broadcaster/pkg/router"
+)
+
+func TestBroadcastTranscodesPerConnection(t *testing.T) {
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "全服互通", Members: []string{"survival", "qq_bot", "web_panel"}, Enabled: true},
+		},
+	}
+	log := nopLogger{}
+	b := NewBroadcaster(router.NewRouter(cfg, log), middleware.NewMiddlewareChain(log), cfg, log)
+
+	survival := newFakeConn("survival")
+	qqBot := newFakeConn("qq_bot")
+	webPanel := newFakeConn("web_panel")
+	webPanel.format = message.FormatStandard
+	for _, conn := range []*fakeConn{survival, qqBot, webPanel} {
+		if err := b.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := &message.Message{From: "survival", Type: "chat", TotalID: "msg-1", CurrentTime: "2024-01-15 10:30:00",
+		Body: message.Body{Sender: "Steve", ChatMessage: "hello"}}
+	data, _ := json.Marshal(msg)
+	if _, err := b.Broadcast(survival, data); err != nil {
+		t.Fatalf("广播失败: %v", err)
+	}
+
+	// 旧版本格式的连接收到from/chatMessage
+	legacy := qqBot.next(t)
+	if legacy["from"] != "survival" || legacy["body"].(map[string]interface{})["chatMessage"] != "hello" {
+		t.Errorf("qq_bot 收到 %v, 期望旧版本格式", legacy)
+	}
+
+	// 新版本格式的连接收到source/body.content
+	standard := webPanel.next(t)
+	body := standard["body"].(map[string]interface{})
+	if standard["source"] != "survival" || standard["from"] != nil || body["content"] != "hello" || body["sender"] != "Steve" {
+		t.Errorf("web_panel 收到 %v, 期望新版本格式", standard)
+	}
+	survival.expectNothing(t)
+}
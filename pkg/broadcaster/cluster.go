@@ -0,0 +1,159 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"sort"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/cluster"
+	"GRUniChat-Broadcaster/pkg/metrics"
+	"GRUniChat-Broadcaster/pkg/utils"
+)
+
+// clusterCapabilities 登记到集群在线信息中的能力，来源节点据此决定是否等待其他节点上的目标ack或命令结果
+var clusterCapabilities = []string{message.CapabilityAck, message.CapabilityCommandResult}
+
+// SetCluster 设置集群节点，并由本广播器处理其他节点发来的消息
+func (b *Broadcaster) SetCluster(c *cluster.Cluster) {
+	b.cluster = c
+	if c != nil {
+		c.SetHandler(b)
+	}
+}
+
+// announce 本地连接变化后更新集群在线信息
+func (b *Broadcaster) announce() {
+	if b.cluster != nil {
+		b.cluster.Announce()
+	}
+}
+
+// LocalServers 实现cluster.Handler接口
+func (b *Broadcaster) LocalServers() map[string][]string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	servers := make(map[string][]string)
+	for _, conn := range b.connections {
+		capabilities := servers[conn.GetID()]
+		for _, capability := range clusterCapabilities {
+			if conn.HasCapability(capability) && !utils.Contains(capabilities, capability) {
+				capabilities = append(capabilities, capability)
+			}
+		}
+		servers[conn.GetID()] = capabilities
+	}
+	return servers
+}
+
+// onlineServers 本节点和其他集群节点上已连接的服务器ID（去重并排序）
+func (b *Broadcaster) onlineServers() []string {
+	servers := b.GetConnections()
+	if b.cluster == nil {
+		return servers
+	}
+
+	seen := make(map[string]bool, len(servers))
+	for _, serverID := range servers {
+		seen[serverID] = true
+	}
+	for _, serverID := range b.cluster.RemoteServers() {
+		if !seen[serverID] {
+			servers = append(servers, serverID)
+		}
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// HandleEnvelope 实现cluster.Handler接口
+func (b *Broadcaster) HandleEnvelope(env *cluster.Envelope) {
+	switch env.Kind {
+	case cluster.KindDeliver:
+		b.deliverFromCluster(env)
+
+	case cluster.KindAck:
+		if b.tracker != nil {
+			b.tracker.Ack(env.TotalID, env.Target)
+		}
+
+	case cluster.KindCommandResult:
+		var result message.Message
+		if err := json.Unmarshal(env.Payload, &result); err != nil {
+			b.logger.Errorf("解析节点 %s 转发的命令结果失败: %v", env.Origin, err)
+			return
+		}
+		if b.commands == nil {
+			return
+		}
+		if err := b.commands.ResolveFrom(env.Target, &result); err != nil {
+			b.logger.Debugf("节点 %s 转发的命令结果未处理: %v", env.Origin, err)
+		}
+	}
+}
+
+// deliverFromCluster 将其他节点路由好的消息发送给本地连接，不再次路由；目标已不在本节点时进入离线队列
+func (b *Broadcaster) deliverFromCluster(env *cluster.Envelope) {
+	b.mu.RLock()
+	sessions := b.sessionsLocked(env.Target)
+	delivered := len(sessions) > 0 && b.sendToSessionsLocked(sessions, env.Payload)
+	b.mu.RUnlock()
+
+	if delivered {
+		b.logger.Debugf("节点 %s 转发的消息已发送到: %s", env.Origin, env.Target)
+		return
+	}
+	if len(sessions) == 0 && b.outbox != nil {
+		if err := b.outbox.Enqueue(env.Target, env.Payload); err == nil {
+			b.logger.Debugf("节点 %s 转发的消息目标已离线，进入离线队列: %s", env.Origin, env.Target)
+			return
+		}
+	}
+	b.logger.Errorf("节点 %s 转发的消息未能发送到: %s", env.Origin, env.Target)
+}
+
+// RemoteJoined 实现cluster.Handler接口，踢出策略下断开本地的同一服务器ID的连接
+func (b *Broadcaster) RemoteJoined(serverID, nodeID string) {
+	if b.config.Server.DuplicatePolicy != config.DuplicatePolicyKick {
+		return
+	}
+
+	b.mu.Lock()
+	sessions := b.sessionsLocked(serverID)
+	for _, old := range sessions {
+		delete(b.connections, old.GetSessionID())
+		old.Kick("同一服务器ID已在其他节点上连接")
+		b.logger.Infof("服务器 %s 已在节点 %s 上连接，踢出本地连接 (会话 %s)", serverID, nodeID, old.GetSessionID())
+	}
+	b.mu.Unlock()
+
+	if len(sessions) > 0 {
+		b.announce()
+	}
+}
+
+// sendToCluster 将本地没有连接的目标的消息转发给其他节点，并将结果记录到result
+func (b *Broadcaster) sendToCluster(msg *message.Message, deliveries []delivery, result *Result) {
+	for _, d := range deliveries {
+		serverID := d.target.ServerID
+		if err := b.cluster.Deliver(serverID, msg.TotalID, d.payload); err != nil {
+			b.logger.Errorf("转发发往 %s 的消息到其他节点失败: %v", serverID, err)
+			result.Failed = append(result.Failed, serverID)
+			metrics.MessagesDropped.WithLabelValues(msg.From, msg.Type, serverID, metrics.DropSendFailed).Inc()
+			continue
+		}
+		b.logger.Debugf("消息已转发到其他节点上的: %s", serverID)
+		result.Sent = append(result.Sent, serverID)
+		metrics.MessagesBroadcast.WithLabelValues(msg.From, msg.Type, serverID).Inc()
+	}
+}
+
+// payloadTotalID 读取内部格式消息的totalId
+func payloadTotalID(payload []byte) string {
+	var msg struct {
+		TotalID string `json:"totalId"`
+	}
+	json.Unmarshal(payload, &msg)
+	return msg.TotalID
+}
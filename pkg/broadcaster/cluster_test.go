@@ -0,0 +1,163 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/cluster"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/middleware"
+	"GRUniChat-Broadcaster/pkg/router"
+)
+
+// nopLogger 丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Info(v ...interface{})                  {}
+func (nopLogger) Infof(format string, v ...interface{})  {}
+func (nopLogger) Error(v ...interface{})                 {}
+func (nopLogger) Errorf(format string, v ...interface{}) {}
+func (nopLogger) Debug(v ...interface{})                 {}
+func (nopLogger) Debugf(format string, v ...interface{}) {}
+
+// fakeConn 记录收到的消息的连接
+type fakeConn struct {
+	id       string
+	format   string // 协商的消息格式，为空时使用旧版本格式
+	received chan []byte
+}
+
+func newFakeConn(id string) *fakeConn {
+	return &fakeConn{id: id, received: make(chan []byte, 16)}
+}
+
+func (c *fakeConn) GetID() string                        { return c.id }
+func (c *fakeConn) GetSessionID() string                 { return c.id + "-session" }
+func (c *fakeConn) IsConnected() bool                    { return true }
+func (c *fakeConn) Kick(reason string)                   {}
+func (c *fakeConn) HasCapability(capability string) bool { return true }
+
+func (c *fakeConn) GetFormat() string {
+	if c.format == "" {
+		return message.FormatLegacy
+	}
+	return c.format
+}
+
+func (c *fakeConn) Send(data []byte) error {
+	c.received <- data
+	return nil
+}
+
+// next 等待连接收到下一条消息
+func (c *fakeConn) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case data := <-c.received:
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("%s 收到无效消息: %v", c.id, err)
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s 未收到消息", c.id)
+		return nil
+	}
+}
+
+// expectNothing 确认连接在短时间内没有收到消息
+func (c *fakeConn) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case data := <-c.received:
+		t.Fatalf("%s 不应收到消息: %s", c.id, data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newClusterNode 创建连接到hub的广播器节点，启用投递确认
+func newClusterNode(t *testing.T, hub *cluster.MemoryHub, nodeID string, cfg *config.Config) *Broadcaster {
+	t.Helper()
+	log := nopLogger{}
+	b := NewBroadcaster(router.NewRouter(cfg, log), middleware.NewMiddlewareChain(log), cfg, log)
+
+	store := database.NewMemoryStore(&config.MemoryConfig{MaxEntries: 1000, MaxBytes: 1 << 20, CleanupInterval: 60})
+	t.Cleanup(func() { store.Close() })
+	b.SetDeliveryTracker(NewDeliveryTracker(&config.DeliveryConfig{AckTimeout: 10}, store, time.Minute, b.SendTo, log))
+
+	node := cluster.New(nodeID, hub.Transport(), time.Minute, time.Hour, log)
+	b.SetCluster(node)
+	if err := node.Start(); err != nil {
+		t.Fatalf("启动节点 %s 失败: %v", nodeID, err)
+	}
+	t.Cleanup(func() { node.Stop() })
+	return b
+}
+
+// waitRemote 等待节点发现其他节点上的服务器
+func waitRemote(t *testing.T, b *Broadcaster, serverID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !b.cluster.HasRemote(serverID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("节点 %s 未发现服务器 %s", b.cluster.NodeID(), serverID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClusterDeliveryAckAndLoop(t *testing.T) {
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "全服互通", Members: []string{"survival", "qq_bot"}, MessageTypes: []string{"chat"}, Enabled: true},
+		},
+	}
+	hub := cluster.NewMemoryHub()
+	node1 := newClusterNode(t, hub, "node-1", cfg)
+	node2 := newClusterNode(t, hub, "node-2", cfg)
+
+	survival := newFakeConn("survival")
+	qqBot := newFakeConn("qq_bot")
+	if err := node1.AddConnection(survival); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.AddConnection(qqBot); err != nil {
+		t.Fatal(err)
+	}
+	waitRemote(t, node1, "qq_bot")
+	waitRemote(t, node2, "survival")
+
+	// 跨节点投递：node-1路由后由node-2发送给本地连接
+	msg := &message.Message{From: "survival", Type: "chat", TotalID: "msg-1", Body: message.Body{Sender: "Steve", ChatMessage: "hello"}}
+	data, _ := json.Marshal(msg)
+	result, err := node1.Broadcast(survival, data)
+	if err != nil {
+		t.Fatalf("广播失败: %v", err)
+	}
+	if len(result.Sent) != 1 || result.Sent[0] != "qq_bot" || !result.Tracked {
+		t.Fatalf("广播结果 = %+v, 期望转发到qq_bot并等待ack", result)
+	}
+	if got := qqBot.next(t); got["totalId"] != "msg-1" || got["body"].(map[string]interface{})["chatMessage"] != "hello" {
+		t.Fatalf("qq_bot 收到 %v", got)
+	}
+
+	// 防回环：客户端把收到的消息原样发回时，node-2不会再次广播
+	if _, err := node2.Broadcast(qqBot, data); !errors.Is(err, middleware.ErrDropped) {
+		t.Fatalf("回环消息应被丢弃，得到 %v", err)
+	}
+	survival.expectNothing(t)
+
+	// ack回传：node-2上的目标确认后，node-1的跟踪器向发送者汇报投递结果
+	node2.HandleAck(qqBot, &message.Message{From: "qq_bot", Type: "ack", TotalID: "msg-1"})
+	report := survival.next(t)
+	if report["type"] != "delivery_report" || report["totalId"] != "msg-1" {
+		t.Fatalf("survival 收到 %v, 期望投递报告", report)
+	}
+	if targets := report["targets"].(map[string]interface{}); targets["qq_bot"] != DeliveryDelivered {
+		t.Errorf("投递报告 = %v, 期望qq_bot已确认", targets)
+	}
+}
@@ -0,0 +1,137 @@
+package broadcaster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// 命令结果相关错误
+var (
+	ErrUnknownCommand   = errors.New("没有等待结果的对应命令")
+	ErrCommandNotTarget = errors.New("只有命令的执行服务器可以返回结果")
+)
+
+// pendingCommand 等待结果的executeAt命令
+type pendingCommand struct {
+	origin    Connection
+	executeAt string
+	timer     *time.Timer
+}
+
+// CommandTracker 关联executeAt命令与其执行结果，结果只回传给发起命令的连接
+type CommandTracker struct {
+	store   database.MessageStoreInterface
+	ttl     time.Duration
+	timeout time.Duration
+	logger  logger.Logger
+	pending map[string]*pendingCommand
+	mu      sync.Mutex
+}
+
+// NewCommandTracker 创建命令结果跟踪器
+func NewCommandTracker(timeout time.Duration, store database.MessageStoreInterface, ttl time.Duration, log logger.Logger) *CommandTracker {
+	return &CommandTracker{
+		store:   store,
+		ttl:     ttl,
+		timeout: timeout,
+		logger:  log,
+		pending: make(map[string]*pendingCommand),
+	}
+}
+
+// SetTimeout 更新等待结果的超时时间（用于热重载）
+func (t *CommandTracker) SetTimeout(timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timeout = timeout
+}
+
+// Register 登记一条发往executeAt服务器的命令
+func (t *CommandTracker) Register(origin Connection, commandID, executeAt string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, exists := t.pending[commandID]; exists {
+		old.timer.Stop()
+	}
+
+	t.pending[commandID] = &pendingCommand{
+		origin:    origin,
+		executeAt: executeAt,
+		timer:     time.AfterFunc(t.timeout, func() { t.onTimeout(commandID) }),
+	}
+}
+
+// Cancel 取消登记（命令未能发出时）
+func (t *CommandTracker) Cancel(commandID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pc, exists := t.pending[commandID]; exists {
+		pc.timer.Stop()
+		delete(t.pending, commandID)
+	}
+}
+
+// Resolve 将执行服务器返回的结果转发给命令发起者
+func (t *CommandTracker) Resolve(executor Connection, result *message.Message) error {
+	return t.ResolveFrom(executor.GetID(), result)
+}
+
+// ResolveFrom 同Resolve，执行服务器以ID指定（用于其他集群节点转发的结果）
+func (t *CommandTracker) ResolveFrom(executorID string, result *message.Message) error {
+	t.mu.Lock()
+	pc, exists := t.pending[result.TotalID]
+	if exists && pc.executeAt != executorID {
+		t.mu.Unlock()
+		return ErrCommandNotTarget
+	}
+	if exists {
+		pc.timer.Stop()
+		delete(t.pending, result.TotalID)
+	}
+	t.mu.Unlock()
+
+	if !exists {
+		return ErrUnknownCommand
+	}
+
+	t.store.SetMessageStatus(result.TotalID, "completed", t.ttl)
+	t.deliver(pc, result)
+	return nil
+}
+
+// onTimeout 执行服务器超时未返回结果，向发起者回复错误结果
+func (t *CommandTracker) onTimeout(commandID string) {
+	t.mu.Lock()
+	pc, exists := t.pending[commandID]
+	if exists {
+		delete(t.pending, commandID)
+	}
+	t.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	t.logger.Errorf("命令 %s 在 %s 上执行超时", commandID, pc.executeAt)
+	t.store.SetMessageStatus(commandID, "timeout", t.ttl)
+	t.deliver(pc, message.NewCommandErrorResult(commandID, pc.executeAt, "命令执行超时"))
+}
+
+// deliver 发送结果给命令发起者
+func (t *CommandTracker) deliver(pc *pendingCommand, result *message.Message) {
+	data, err := message.Encode(result, pc.origin.GetFormat())
+	if err != nil {
+		t.logger.Errorf("序列化命令结果失败: %v", err)
+		return
+	}
+	if err := pc.origin.Send(data); err != nil {
+		t.logger.Errorf("发送命令结果到 %s 失败: %v", pc.origin.GetID(), err)
+	}
+}
@@ -0,0 +1,268 @@
+package broadcaster
+
+import (
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"   // 已发送，等待目标ack
+	DeliveryDelivered = "delivered" // 目标已ack
+	DeliveryFailed    = "failed"    // 重试耗尽仍未ack
+	DeliveryQueued    = "queued"    // 目标离线，已进入离线队列
+)
+
+// SendFunc 将消息直接发送到指定服务器
+type SendFunc func(serverID string, payload []byte) error
+
+// targetDelivery 单个目标的投递状态
+type targetDelivery struct {
+	payload  []byte
+	status   string
+	attempts int
+}
+
+// pendingDelivery 一条消息的投递跟踪记录
+type pendingDelivery struct {
+	origin  Connection
+	targets map[string]*targetDelivery
+	timer   *time.Timer
+}
+
+// DeliveryTracker 跟踪目标客户端的ack，超时重发，并向发送者汇报投递结果
+type DeliveryTracker struct {
+	store      database.MessageStoreInterface
+	ttl        time.Duration
+	ackTimeout time.Duration
+	maxRetries int
+	send       SendFunc
+	logger     logger.Logger
+	pending    map[string]*pendingDelivery
+	mu         sync.Mutex
+}
+
+// NewDeliveryTracker 创建投递确认跟踪器
+func NewDeliveryTracker(cfg *config.DeliveryConfig, store database.MessageStoreInterface, ttl time.Duration, send SendFunc, log logger.Logger) *DeliveryTracker {
+	return &DeliveryTracker{
+		store:      store,
+		ttl:        ttl,
+		ackTimeout: time.Duration(cfg.AckTimeout) * time.Second,
+		maxRetries: cfg.MaxRetries,
+		send:       send,
+		logger:     log,
+		pending:    make(map[string]*pendingDelivery),
+	}
+}
+
+// UpdateConfig 更新超时和重试配置（用于热重载），已在跟踪中的消息沿用新配置
+func (t *DeliveryTracker) UpdateConfig(cfg *config.DeliveryConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ackTimeout = time.Duration(cfg.AckTimeout) * time.Second
+	t.maxRetries = cfg.MaxRetries
+}
+
+// deliveryUpdate 锁内收集的状态变更和投递报告，释放锁后再写入存储和发送
+type deliveryUpdate struct {
+	messageID string
+	targets   map[string]string // 目标服务器ID -> 新的投递状态
+	status    string            // 消息的最终状态，为空表示尚未完成
+	origin    Connection
+	report    map[string]string // 投递报告，为nil表示不发送
+}
+
+// newDeliveryUpdate 创建消息的状态变更记录
+func newDeliveryUpdate(messageID string) *deliveryUpdate {
+	return &deliveryUpdate{
+		messageID: messageID,
+		targets:   make(map[string]string),
+	}
+}
+
+// Track 登记一条待确认的消息，所有目标初始为pending
+func (t *DeliveryTracker) Track(origin Connection, messageID string, deliveries []delivery) {
+	// 消息登记后才会发送，ack不会早于这里的状态写入
+	for _, d := range deliveries {
+		t.store.SetDeliveryStatus(messageID, d.target.ServerID, DeliveryPending, t.ttl)
+	}
+	t.store.SetMessageStatus(messageID, "awaiting_ack", t.ttl)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pd := &pendingDelivery{
+		origin:  origin,
+		targets: make(map[string]*targetDelivery, len(deliveries)),
+	}
+	for _, d := range deliveries {
+		pd.targets[d.target.ServerID] = &targetDelivery{
+			payload:  d.payload,
+			status:   DeliveryPending,
+			attempts: 1,
+		}
+	}
+
+	pd.timer = time.AfterFunc(t.ackTimeout, func() { t.onTimeout(messageID) })
+	t.pending[messageID] = pd
+}
+
+// Settle 根据首次发送结果更新状态：离线目标标记为queued，未发送的目标不再跟踪；
+// 不参与跟踪的目标（不支持ack）发送失败时也记为failed，计入最终状态和投递报告
+func (t *DeliveryTracker) Settle(messageID string, result *Result) {
+	t.mu.Lock()
+	pd, exists := t.pending[messageID]
+	if !exists {
+		t.mu.Unlock()
+		return
+	}
+
+	update := newDeliveryUpdate(messageID)
+	for _, serverID := range result.Queued {
+		if td, ok := pd.targets[serverID]; ok {
+			td.status = DeliveryQueued
+			update.targets[serverID] = DeliveryQueued
+		}
+	}
+	for _, serverID := range result.Skipped {
+		delete(pd.targets, serverID)
+	}
+	for _, serverID := range result.Failed {
+		if _, ok := pd.targets[serverID]; !ok {
+			pd.targets[serverID] = &targetDelivery{status: DeliveryFailed}
+			update.targets[serverID] = DeliveryFailed
+		}
+	}
+
+	t.finishIfCompleteLocked(pd, update)
+	t.mu.Unlock()
+
+	t.apply(update)
+}
+
+// Ack 处理目标对消息的确认
+func (t *DeliveryTracker) Ack(messageID, serverID string) {
+	t.mu.Lock()
+	pd, exists := t.pending[messageID]
+	if !exists {
+		t.mu.Unlock()
+		t.logger.Debugf("收到未跟踪消息的ack: %s from %s", messageID, serverID)
+		return
+	}
+
+	td, ok := pd.targets[serverID]
+	if !ok || td.status == DeliveryDelivered {
+		t.mu.Unlock()
+		return
+	}
+
+	td.status = DeliveryDelivered
+	update := newDeliveryUpdate(messageID)
+	update.targets[serverID] = DeliveryDelivered
+	t.finishIfCompleteLocked(pd, update)
+	t.mu.Unlock()
+
+	t.logger.Debugf("消息 %s 已被 %s 确认", messageID, serverID)
+	t.apply(update)
+}
+
+// onTimeout ack超时处理：未确认的目标重发，重试耗尽则标记失败
+func (t *DeliveryTracker) onTimeout(messageID string) {
+	t.mu.Lock()
+	pd, exists := t.pending[messageID]
+	if !exists {
+		t.mu.Unlock()
+		return
+	}
+
+	update := newDeliveryUpdate(messageID)
+	for serverID, td := range pd.targets {
+		if td.status != DeliveryPending {
+			continue
+		}
+
+		if td.attempts > t.maxRetries {
+			td.status = DeliveryFailed
+			update.targets[serverID] = DeliveryFailed
+			t.logger.Errorf("消息 %s 在 %s 上重试 %d 次后仍未确认", messageID, serverID, t.maxRetries)
+			continue
+		}
+
+		td.attempts++
+		if err := t.send(serverID, td.payload); err != nil {
+			t.logger.Errorf("重发消息 %s 到 %s 失败: %v", messageID, serverID, err)
+		} else {
+			t.logger.Infof("重发消息 %s 到 %s (第 %d 次)", messageID, serverID, td.attempts-1)
+		}
+	}
+
+	if !t.finishIfCompleteLocked(pd, update) {
+		pd.timer.Reset(t.ackTimeout)
+	}
+	t.mu.Unlock()
+
+	t.apply(update)
+}
+
+// finishIfCompleteLocked 所有目标都不再是pending时结束跟踪，并把最终状态和投递报告记入update，调用方需持有锁
+func (t *DeliveryTracker) finishIfCompleteLocked(pd *pendingDelivery, update *deliveryUpdate) bool {
+	report := make(map[string]string, len(pd.targets))
+	delivered, failed := 0, 0
+	for serverID, td := range pd.targets {
+		switch td.status {
+		case DeliveryPending:
+			return false
+		case DeliveryDelivered:
+			delivered++
+		case DeliveryFailed:
+			failed++
+		}
+		// 离线队列中的目标在报告中视为pending
+		if td.status == DeliveryQueued {
+			report[serverID] = DeliveryPending
+		} else {
+			report[serverID] = td.status
+		}
+	}
+
+	pd.timer.Stop()
+	delete(t.pending, update.messageID)
+
+	update.status = "delivered"
+	switch {
+	case failed > 0 && delivered == 0 && failed == len(pd.targets):
+		update.status = "failed"
+	case failed > 0:
+		update.status = "partial"
+	case delivered < len(pd.targets):
+		update.status = "queued"
+	}
+	update.origin = pd.origin
+	update.report = report
+	return true
+}
+
+// apply 写入状态变更，消息完成时按发送者协商的格式发送投递报告，调用方不能持有锁
+func (t *DeliveryTracker) apply(update *deliveryUpdate) {
+	for serverID, status := range update.targets {
+		t.store.SetDeliveryStatus(update.messageID, serverID, status, t.ttl)
+	}
+	if update.status == "" {
+		return
+	}
+	t.store.SetMessageStatus(update.messageID, update.status, t.ttl)
+
+	data, err := message.EncodeDeliveryReport(message.NewDeliveryReport(update.messageID, update.report), update.origin.GetFormat())
+	if err != nil {
+		t.logger.Errorf("序列化投递报告失败: %v", err)
+		return
+	}
+	if err := update.origin.Send(data); err != nil {
+		t.logger.Debugf("发送投递报告到 %s 失败: %v", update.origin.GetID(), err)
+	}
+}
@@ -0,0 +1,37 @@
+package broadcaster
+
+import (
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/router"
+)
+
+func TestDeliveryReportIncludesUntrackedFailures(t *testing.T) {
+	store := database.NewMemoryStore(&config.MemoryConfig{MaxEntries: 100, MaxBytes: 1 << 20, CleanupInterval: 60})
+	defer store.Close()
+	send := func(serverID string, payload []byte) error { return nil }
+	tracker := NewDeliveryTracker(&config.DeliveryConfig{AckTimeout: 10}, store, time.Minute, send, nopLogger{})
+
+	// qq_bot支持ack参与跟踪，legacy_bot不支持ack且发送失败
+	origin := newFakeConn("survival")
+	tracker.Track(origin, "msg-1", []delivery{{target: router.Target{ServerID: "qq_bot"}, payload: []byte("{}")}})
+	tracker.Settle("msg-1", &Result{Sent: []string{"qq_bot"}, Failed: []string{"legacy_bot"}, Tracked: true})
+	origin.expectNothing(t)
+
+	tracker.Ack("msg-1", "qq_bot")
+	report := origin.next(t)
+	targets := report["targets"].(map[string]interface{})
+	if targets["qq_bot"] != DeliveryDelivered || targets["legacy_bot"] != DeliveryFailed || report["failed"] != float64(1) {
+		t.Fatalf("投递报告 = %v, 期望qq_bot已确认、legacy_bot失败", report)
+	}
+
+	if status, _ := store.GetMessageStatus("msg-1"); status != "partial" {
+		t.Errorf("消息状态 = %s, 期望 partial", status)
+	}
+	if statuses, _ := store.GetDeliveryStatuses("msg-1"); statuses["legacy_bot"] != DeliveryFailed {
+		t.Errorf("投递状态 = %v, 期望legacy_bot失败", statuses)
+	}
+}
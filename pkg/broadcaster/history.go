@@ -0,0 +1,156 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/middleware"
+	"GRUniChat-Broadcaster/pkg/router"
+	"GRUniChat-Broadcaster/pkg/utils"
+)
+
+var (
+	// ErrHistoryDisabled 未启用历史消息
+	ErrHistoryDisabled = errors.New("历史消息未启用")
+	// ErrInvalidHistoryRequest 历史消息请求的条件无效
+	ErrInvalidHistoryRequest = errors.New("无效的历史消息请求")
+)
+
+// History 历史消息记录，供客户端连接后请求回放
+type History struct {
+	store  database.MessageStoreInterface
+	config config.HistoryConfig
+	logger logger.Logger
+}
+
+// NewHistory 创建历史消息记录
+func NewHistory(store database.MessageStoreInterface, cfg *config.HistoryConfig, log logger.Logger) *History {
+	return &History{
+		store:  store,
+		config: *cfg,
+		logger: log,
+	}
+}
+
+// Record 记录一条已广播的消息，groups为来源所在的群组
+func (h *History) Record(msg *message.Message, groups []string) {
+	if !utils.Contains(h.config.MessageTypes, msg.Type) {
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	entry := database.HistoryEntry{
+		MessageID: msg.TotalID,
+		Source:    msg.From,
+		Type:      msg.Type,
+		Groups:    groups,
+		Time:      time.Now(),
+		Data:      data,
+	}
+	ttl := time.Duration(h.config.TTL) * time.Second
+	if err := h.store.AppendHistory(entry, ttl, h.config.MaxEntries); err != nil {
+		h.logger.Errorf("记录历史消息失败: %v", err)
+	}
+}
+
+// Query 按客户端请求查询历史消息，条数限制在配置范围内
+func (h *History) Query(req *message.HistoryRequest) ([]database.HistoryEntry, error) {
+	query := database.HistoryQuery{Limit: h.config.DefaultLimit}
+	if req != nil {
+		since, err := req.ParseSince()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHistoryRequest, err)
+		}
+		query.Group = req.Group
+		query.Source = req.Source
+		query.Since = since
+		query.AfterID = req.AfterID
+		if req.Limit > 0 {
+			query.Limit = req.Limit
+		}
+	}
+	if query.Limit > h.config.MaxLimit {
+		query.Limit = h.config.MaxLimit
+	}
+	return h.store.QueryHistory(query)
+}
+
+// SetHistory 设置历史消息记录
+func (b *Broadcaster) SetHistory(history *History) {
+	b.history = history
+}
+
+// recordHistory 记录已广播的消息及其来源所在的群组
+func (b *Broadcaster) recordHistory(msg *message.Message) {
+	if b.history == nil {
+		return
+	}
+
+	var groups []string
+	for _, group := range b.config.Groups {
+		if group.Enabled && utils.Contains(group.Members, msg.From) {
+			groups = append(groups, group.Name)
+		}
+	}
+	b.history.Record(msg, groups)
+}
+
+// ReplayHistory 向连接回放历史消息
+//
+// 每条消息都按当前路由规则检查是否会发往该连接，并经过黑名单、路由后中间件和转换规则，
+// 与实时广播时该连接会收到的内容一致；路由后中间件以回放模式运行，不会重复记录审核结果。返回实际回放的条数。
+func (b *Broadcaster) ReplayHistory(conn Connection, req *message.HistoryRequest) (int, error) {
+	if b.history == nil {
+		return 0, ErrHistoryDisabled
+	}
+
+	entries, err := b.history.Query(req)
+	if err != nil {
+		return 0, err
+	}
+
+	serverID := conn.GetID()
+	connected := b.onlineServers()
+	replayed := 0
+	for _, entry := range entries {
+		var msg message.Message
+		if err := json.Unmarshal(entry.Data, &msg); err != nil {
+			continue
+		}
+
+		target, ok := router.FindTarget(b.router.GetTargets(&msg, connected), serverID)
+		if !ok || b.shouldBlockMessage(&msg, target) {
+			continue
+		}
+
+		groupName := ""
+		if group := b.targetGroup(target); group != nil {
+			groupName = group.Name
+		}
+		targetMsg, err := b.middleware.ProcessTarget(&middleware.Context{ServerID: msg.From, Replay: true}, &msg, groupName)
+		if err != nil || targetMsg == nil {
+			continue
+		}
+
+		payload, err := b.buildTargetPayload(targetMsg, map[*message.Message][]byte{&msg: entry.Data}, target.Transform)
+		if err != nil {
+			continue
+		}
+		if err := sendEncoded(conn, payload); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	b.logger.Infof("已向 %s (会话 %s) 回放 %d 条历史消息", serverID, conn.GetSessionID(), replayed)
+	return replayed, nil
+}
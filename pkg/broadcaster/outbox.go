@@ -0,0 +1,58 @@
+package broadcaster
+
+import (
+	"time"
+
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// Outbox 离线消息队列，为离线的目标缓存消息并在其重新连接后按顺序重放
+type Outbox struct {
+	store   database.MessageStoreInterface
+	ttl     time.Duration
+	maxSize int
+	logger  logger.Logger
+}
+
+// NewOutbox 创建离线消息队列
+func NewOutbox(store database.MessageStoreInterface, ttl time.Duration, maxSize int, log logger.Logger) *Outbox {
+	return &Outbox{
+		store:   store,
+		ttl:     ttl,
+		maxSize: maxSize,
+		logger:  log,
+	}
+}
+
+// Enqueue 为离线目标缓存一条已生成好的消息
+func (o *Outbox) Enqueue(target string, payload []byte) error {
+	if err := o.store.EnqueueOffline(target, payload, o.ttl, o.maxSize); err != nil {
+		return err
+	}
+	o.logger.Debugf("目标 %s 离线，消息已加入离线队列", target)
+	return nil
+}
+
+// Replay 将目标的离线消息按顺序发送到新连接，发送失败的剩余消息重新入队
+func (o *Outbox) Replay(conn Connection) (int, error) {
+	messages, err := o.store.DequeueOffline(conn.GetID())
+	if err != nil {
+		return 0, err
+	}
+
+	for i, payload := range messages {
+		if err := sendEncoded(conn, payload); err != nil {
+			o.logger.Errorf("重放离线消息到 %s 失败，剩余 %d 条重新入队: %v", conn.GetID(), len(messages)-i, err)
+			for _, remaining := range messages[i:] {
+				o.store.EnqueueOffline(conn.GetID(), remaining, o.ttl, o.maxSize)
+			}
+			return i, err
+		}
+	}
+
+	if len(messages) > 0 {
+		o.logger.Infof("已向 %s 重放 %d 条离线消息", conn.GetID(), len(messages))
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,161 @@
+package broadcaster
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// 消息统计计数器的名称前缀
+const (
+	sourceCounterPrefix = "messages:source:"
+	typeCounterPrefix   = "messages:type:"
+)
+
+// statsFlushInterval 缓冲的统计增量写入存储的间隔
+const statsFlushInterval = 5 * time.Second
+
+// MessageStats 按来源和类型统计通过中间件的消息数
+//
+// 计数保存在消息存储的计数器中，Redis和SQL存储上重启后继续累加，多个集群节点共用存储时合并统计。
+// Record 只在内存中累加增量，后台协程定期把增量合并写入存储，广播路径上不访问存储；
+// Stop 时写入剩余的增量，异常退出最多丢失一个写入间隔内的计数。
+type MessageStats struct {
+	store  database.MessageStoreInterface
+	logger logger.Logger
+
+	mu      sync.Mutex
+	pending map[string]int64 // 尚未写入存储的增量
+
+	flushMu  sync.Mutex // 写入期间增量既不在pending中也未到达存储，快照等待写入完成
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMessageStats 创建消息统计并启动后台写入
+func NewMessageStats(store database.MessageStoreInterface, log logger.Logger) *MessageStats {
+	s := &MessageStats{
+		store:   store,
+		logger:  log,
+		pending: make(map[string]int64),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.flushLoop(statsFlushInterval)
+	return s
+}
+
+// Record 记录一条来自source的msgType类型消息
+func (s *MessageStats) Record(source, msgType string) {
+	s.mu.Lock()
+	s.pending[sourceCounterPrefix+source]++
+	s.pending[typeCounterPrefix+msgType]++
+	s.mu.Unlock()
+}
+
+// flushLoop 定期写入缓冲的增量，停止时最后写入一次
+func (s *MessageStats) flushLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush 将缓冲的增量写入存储，写入失败的增量放回缓冲区，下次重试
+func (s *MessageStats) flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	deltas := s.pending
+	s.pending = make(map[string]int64, len(deltas))
+	s.mu.Unlock()
+
+	var failed map[string]int64
+	for key, delta := range deltas {
+		if _, err := s.store.AddCounter(key, delta); err != nil {
+			s.logger.Errorf("写入消息统计 %s 失败: %v", key, err)
+			if failed == nil {
+				failed = make(map[string]int64)
+			}
+			failed[key] = delta
+		}
+	}
+
+	if failed != nil {
+		s.mu.Lock()
+		for key, delta := range failed {
+			s.pending[key] += delta
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Stop 停止后台写入并写入剩余的增量，需要在关闭存储之前调用
+func (s *MessageStats) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// Snapshot 获取各来源和各类型的累计消息数，包括尚未写入存储的增量
+func (s *MessageStats) Snapshot() (map[string]interface{}, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	bySource, err := s.list(sourceCounterPrefix)
+	if err != nil {
+		return nil, err
+	}
+	byType, err := s.list(typeCounterPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	for key, delta := range s.pending {
+		if strings.HasPrefix(key, sourceCounterPrefix) {
+			bySource[strings.TrimPrefix(key, sourceCounterPrefix)] += delta
+		} else if strings.HasPrefix(key, typeCounterPrefix) {
+			byType[strings.TrimPrefix(key, typeCounterPrefix)] += delta
+		}
+	}
+	s.mu.Unlock()
+
+	return map[string]interface{}{
+		"by_source": bySource,
+		"by_type":   byType,
+	}, nil
+}
+
+// list 列出前缀下的计数器，键去掉前缀
+func (s *MessageStats) list(prefix string) (map[string]int64, error) {
+	counters, err := s.store.ListCounters(prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(counters))
+	for key, value := range counters {
+		result[strings.TrimPrefix(key, prefix)] = value
+	}
+	return result, nil
+}
+
+// SetMessageStats 设置消息统计
+func (b *Broadcaster) SetMessageStats(stats *MessageStats) {
+	b.stats = stats
+}
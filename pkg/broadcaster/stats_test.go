@@ -0,0 +1,51 @@
+package broadcaster
+
+import (
+	"testing"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/pkg/database"
+)
+
+func TestMessageStatsBuffersUntilFlush(t *testing.T) {
+	store := database.NewMemoryStore(&config.MemoryConfig{MaxEntries: 100, MaxBytes: 1 << 20, CleanupInterval: 60})
+	defer store.Close()
+	if _, err := store.AddCounter(sourceCounterPrefix+"QQ", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := NewMessageStats(store, nopLogger{})
+	stats.Record("QQ", "chat")
+	stats.Record("QQ", "chat")
+	stats.Record("survival", "event")
+
+	// 增量只在内存中累加，写入间隔到达前不访问存储
+	if value, _ := store.GetCounter(typeCounterPrefix + "chat"); value != 0 {
+		t.Fatalf("写入前存储中的计数 = %d, 期望 0", value)
+	}
+
+	// 快照合并存储中的计数和尚未写入的增量
+	snapshot, err := stats.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySource := snapshot["by_source"].(map[string]int64)
+	byType := snapshot["by_type"].(map[string]int64)
+	if bySource["QQ"] != 7 || bySource["survival"] != 1 || byType["chat"] != 2 || byType["event"] != 1 {
+		t.Fatalf("快照 = %v", snapshot)
+	}
+
+	// 停止时写入剩余的增量
+	stats.Stop()
+	for key, want := range map[string]int64{
+		sourceCounterPrefix + "QQ":       7,
+		sourceCounterPrefix + "survival": 1,
+		typeCounterPrefix + "chat":       2,
+		typeCounterPrefix + "event":      1,
+	} {
+		if value, err := store.GetCounter(key); err != nil || value != want {
+			t.Errorf("%s = %d, %v, 期望 %d", key, value, err, want)
+		}
+	}
+	stats.Stop() // 重复停止不会阻塞
+}
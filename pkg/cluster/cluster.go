@@ -0,0 +1,450 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
+)
+
+// 节点间消息类型
+const (
+	KindDeliver       = "deliver"        // 发往其他节点上的服务器的消息
+	KindAck           = "ack"            // 目标服务器的投递确认，回传给消息的来源节点
+	KindCommandResult = "command_result" // executeAt命令的执行结果，回传给命令的来源节点
+	KindJoin          = "join"           // 服务器连接到节点
+	KindLeave         = "leave"          // 服务器从节点断开
+)
+
+const (
+	eventsChannel = "cluster:events" // 所有节点订阅的在线信息变更频道
+	receivedTTL   = 10 * time.Minute // 从其他节点收到的消息ID保留时间，需覆盖ack和命令结果的等待时间
+)
+
+// nodeChannel 节点自己的消息频道
+func nodeChannel(nodeID string) string {
+	return "cluster:node:" + nodeID
+}
+
+// ErrServerNotFound 没有其他节点连接了该服务器
+var ErrServerNotFound = errors.New("其他节点上没有该服务器")
+
+// Envelope 节点间传递的消息
+type Envelope struct {
+	Kind         string              `json:"kind"`
+	Origin       string              `json:"origin"`                 // 发出该消息的节点
+	TotalID      string              `json:"totalId,omitempty"`      // 原消息的totalId
+	Target       string              `json:"target,omitempty"`       // deliver为目标服务器，ack和command_result为确认或执行的服务器
+	Servers      []string            `json:"servers,omitempty"`      // join和leave涉及的服务器
+	Capabilities map[string][]string `json:"capabilities,omitempty"` // join时各服务器协商的能力
+	Payload      []byte              `json:"payload,omitempty"`      // 内部格式的消息
+}
+
+// Handler 处理其他节点发来的消息，由广播器实现
+type Handler interface {
+	// LocalServers 本节点已连接的服务器ID及其各会话协商的能力合集
+	LocalServers() map[string][]string
+	// HandleEnvelope 处理deliver、ack和command_result消息
+	HandleEnvelope(env *Envelope)
+	// RemoteJoined 服务器连接到了其他节点
+	RemoteJoined(serverID, nodeID string)
+}
+
+// receivedMessage 从其他节点收到的消息
+type receivedMessage struct {
+	origin string
+	at     time.Time
+}
+
+// Cluster 集群节点：登记本节点上的服务器，把发往其他节点的消息通过Transport转发
+//
+// 路由、中间件和转换规则都在消息的来源节点执行，其他节点只把收到的消息发送给本地连接，不会再次路由；
+// 从其他节点收到的消息按totalId记录来源，同一消息再次进入广播时视为回环丢弃，
+// 目标的ack和命令结果也据此回传给来源节点。
+type Cluster struct {
+	nodeID      string
+	transport   Transport
+	presenceTTL time.Duration
+	interval    time.Duration
+	logger      logger.Logger
+	handler     Handler
+	announced   map[string]bool            // 本节点已登记的服务器
+	remote      map[string][]Member        // 其他节点上的服务器
+	received    map[string]receivedMessage // 从其他节点收到的消息totalId -> 来源
+	stop        chan struct{}
+	stopOnce    sync.Once
+	announceMu  sync.Mutex // 保证登记顺序与本地连接变化一致
+	mu          sync.RWMutex
+}
+
+// New 创建集群节点
+func New(nodeID string, transport Transport, presenceTTL, interval time.Duration, log logger.Logger) *Cluster {
+	return &Cluster{
+		nodeID:      nodeID,
+		transport:   transport,
+		presenceTTL: presenceTTL,
+		interval:    interval,
+		logger:      log,
+		announced:   make(map[string]bool),
+		remote:      make(map[string][]Member),
+		received:    make(map[string]receivedMessage),
+		stop:        make(chan struct{}),
+	}
+}
+
+// NodeID 本节点ID
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// SetHandler 设置处理其他节点消息的广播器（热重载后切换到新的广播器）
+func (c *Cluster) SetHandler(handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+// getHandler 获取当前的广播器
+func (c *Cluster) getHandler() Handler {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.handler
+}
+
+// Start 订阅本节点和在线信息频道，登记本地服务器并开始定期刷新，需先调用SetHandler
+func (c *Cluster) Start() error {
+	messages, err := c.transport.Subscribe(nodeChannel(c.nodeID), eventsChannel)
+	if err != nil {
+		return err
+	}
+	go c.receiveLoop(messages)
+
+	c.refresh()
+	go c.heartbeatLoop()
+
+	c.logger.Infof("集群节点 %s 已启动，其他节点上的服务器: %d", c.nodeID, len(c.RemoteServers()))
+	return nil
+}
+
+// Stop 删除本节点的在线信息并关闭通道
+func (c *Cluster) Stop() error {
+	var err error
+	c.stopOnce.Do(func() {
+		close(c.stop)
+
+		c.announceMu.Lock()
+		servers := make([]string, 0, len(c.announced))
+		for serverID := range c.announced {
+			servers = append(servers, serverID)
+		}
+		c.announced = make(map[string]bool)
+		c.announceMu.Unlock()
+
+		if err := c.transport.RemovePresence(c.nodeID, servers); err != nil {
+			c.logger.Errorf("删除集群在线信息失败: %v", err)
+		}
+		if len(servers) > 0 {
+			c.publish(eventsChannel, &Envelope{Kind: KindLeave, Servers: servers})
+		}
+		err = c.transport.Close()
+		c.logger.Infof("集群节点 %s 已停止", c.nodeID)
+	})
+	return err
+}
+
+// Announce 根据本地连接更新本节点登记的服务器，并通知其他节点
+func (c *Cluster) Announce() {
+	handler := c.getHandler()
+	if handler == nil {
+		return
+	}
+
+	c.announceMu.Lock()
+	defer c.announceMu.Unlock()
+
+	current := handler.LocalServers()
+	var joined, left []string
+	seen := make(map[string]bool, len(current))
+	for serverID := range current {
+		seen[serverID] = true
+		if !c.announced[serverID] {
+			joined = append(joined, serverID)
+		}
+	}
+	for serverID := range c.announced {
+		if !seen[serverID] {
+			left = append(left, serverID)
+		}
+	}
+	c.announced = seen
+
+	// 每次都刷新全部服务器的过期时间
+	if err := c.transport.SetPresence(c.nodeID, current, c.presenceTTL); err != nil {
+		c.logger.Errorf("登记集群在线信息失败: %v", err)
+	}
+	if err := c.transport.RemovePresence(c.nodeID, left); err != nil {
+		c.logger.Errorf("删除集群在线信息失败: %v", err)
+	}
+	if len(joined) > 0 {
+		capabilities := make(map[string][]string, len(joined))
+		for _, serverID := range joined {
+			capabilities[serverID] = current[serverID]
+		}
+		c.publish(eventsChannel, &Envelope{Kind: KindJoin, Servers: joined, Capabilities: capabilities})
+	}
+	if len(left) > 0 {
+		c.publish(eventsChannel, &Envelope{Kind: KindLeave, Servers: left})
+	}
+}
+
+// RemoteServers 其他节点上的服务器ID（排序）
+func (c *Cluster) RemoteServers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	servers := make([]string, 0, len(c.remote))
+	for serverID := range c.remote {
+		servers = append(servers, serverID)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// HasRemote 检查服务器是否连接在其他节点上
+func (c *Cluster) HasRemote(serverID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.remote[serverID]) > 0
+}
+
+// RemoteSupports 检查其他节点上的服务器是否协商了指定能力，任一节点上支持即可
+func (c *Cluster) RemoteSupports(serverID, capability string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, member := range c.remote[serverID] {
+		for _, supported := range member.Capabilities {
+			if supported == capability {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsRemoteMessage 检查消息是否是从其他节点收到的
+func (c *Cluster) IsRemoteMessage(totalID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, exists := c.received[totalID]
+	return exists
+}
+
+// Deliver 将消息发送到连接了该服务器的其他节点
+func (c *Cluster) Deliver(serverID, totalID string, payload []byte) error {
+	c.mu.RLock()
+	members := append([]Member(nil), c.remote[serverID]...)
+	c.mu.RUnlock()
+
+	if len(members) == 0 {
+		return ErrServerNotFound
+	}
+
+	var lastErr error
+	delivered := false
+	for _, member := range members {
+		env := &Envelope{Kind: KindDeliver, TotalID: totalID, Target: serverID, Payload: payload}
+		if err := c.publish(nodeChannel(member.NodeID), env); err != nil {
+			lastErr = err
+			continue
+		}
+		delivered = true
+	}
+	if !delivered {
+		return lastErr
+	}
+	return nil
+}
+
+// ForwardToOrigin 将从其他节点收到的消息的ack或命令结果回传给来源节点，消息不是从其他节点收到的时返回false
+func (c *Cluster) ForwardToOrigin(kind, totalID, serverID string, payload []byte) (bool, error) {
+	c.mu.RLock()
+	received, exists := c.received[totalID]
+	c.mu.RUnlock()
+
+	if !exists {
+		return false, nil
+	}
+	env := &Envelope{Kind: kind, TotalID: totalID, Target: serverID, Payload: payload}
+	return true, c.publish(nodeChannel(received.origin), env)
+}
+
+// GetStats 获取集群统计信息
+func (c *Cluster) GetStats() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	remote := make(map[string][]Member, len(c.remote))
+	for serverID, members := range c.remote {
+		remote[serverID] = append([]Member(nil), members...)
+	}
+	return map[string]interface{}{
+		"node_id":           c.nodeID,
+		"remote_servers":    remote,
+		"received_messages": len(c.received),
+	}
+}
+
+// publish 序列化并发布消息
+func (c *Cluster) publish(channel string, env *Envelope) error {
+	env.Origin = c.nodeID
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if err := c.transport.Publish(channel, data); err != nil {
+		c.logger.Errorf("发布集群消息失败: %v", err)
+		return err
+	}
+	metrics.ClusterMessages.WithLabelValues("sent", env.Kind).Inc()
+	return nil
+}
+
+// receiveLoop 处理订阅收到的消息，直到通道关闭
+func (c *Cluster) receiveLoop(messages <-chan []byte) {
+	for data := range messages {
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			c.logger.Errorf("解析集群消息失败: %v", err)
+			continue
+		}
+		if env.Origin == c.nodeID {
+			continue // 在线信息频道会收到自己发布的消息
+		}
+		metrics.ClusterMessages.WithLabelValues("received", env.Kind).Inc()
+		c.handleEnvelope(&env)
+	}
+}
+
+// handleEnvelope 处理一条其他节点发来的消息
+func (c *Cluster) handleEnvelope(env *Envelope) {
+	handler := c.getHandler()
+
+	switch env.Kind {
+	case KindJoin:
+		c.mu.Lock()
+		for _, serverID := range env.Servers {
+			c.addRemoteLocked(serverID, Member{NodeID: env.Origin, Capabilities: env.Capabilities[serverID]})
+		}
+		c.mu.Unlock()
+		c.logger.Infof("节点 %s 上线服务器: %v", env.Origin, env.Servers)
+		if handler != nil {
+			for _, serverID := range env.Servers {
+				handler.RemoteJoined(serverID, env.Origin)
+			}
+		}
+
+	case KindLeave:
+		c.mu.Lock()
+		for _, serverID := range env.Servers {
+			c.removeRemoteLocked(serverID, env.Origin)
+		}
+		c.mu.Unlock()
+		c.logger.Infof("节点 %s 下线服务器: %v", env.Origin, env.Servers)
+
+	case KindDeliver:
+		if env.TotalID != "" {
+			c.mu.Lock()
+			c.received[env.TotalID] = receivedMessage{origin: env.Origin, at: time.Now()}
+			c.mu.Unlock()
+		}
+		if handler != nil {
+			handler.HandleEnvelope(env)
+		}
+
+	case KindAck, KindCommandResult:
+		if handler != nil {
+			handler.HandleEnvelope(env)
+		}
+
+	default:
+		c.logger.Debugf("忽略未知的集群消息类型: %s", env.Kind)
+	}
+}
+
+// heartbeatLoop 定期刷新本节点的在线信息和其他节点的服务器列表
+func (c *Cluster) heartbeatLoop() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// refresh 刷新在线信息，并清理过期的已收消息记录
+func (c *Cluster) refresh() {
+	c.Announce()
+
+	presence, err := c.transport.Presence()
+	if err != nil {
+		c.logger.Errorf("获取集群在线信息失败: %v", err)
+		return
+	}
+
+	remote := make(map[string][]Member)
+	for serverID, members := range presence {
+		for _, member := range members {
+			if member.NodeID != c.nodeID {
+				remote[serverID] = append(remote[serverID], member)
+			}
+		}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	c.remote = remote
+	for totalID, received := range c.received {
+		if now.Sub(received.at) > receivedTTL {
+			delete(c.received, totalID)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// addRemoteLocked 记录其他节点上的服务器，调用方需持有锁
+func (c *Cluster) addRemoteLocked(serverID string, member Member) {
+	members := c.remote[serverID]
+	for i, existing := range members {
+		if existing.NodeID == member.NodeID {
+			members[i] = member
+			return
+		}
+	}
+	c.remote[serverID] = append(members, member)
+}
+
+// removeRemoteLocked 删除其他节点上的服务器，调用方需持有锁
+func (c *Cluster) removeRemoteLocked(serverID, nodeID string) {
+	members := c.remote[serverID]
+	for i, existing := range members {
+		if existing.NodeID == nodeID {
+			members = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(members) == 0 {
+		delete(c.remote, serverID)
+	} else {
+		c.remote[serverID] = members
+	}
+}
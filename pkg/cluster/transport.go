@@ -0,0 +1,307 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Transport 节点间的消息通道和共享的在线信息
+type Transport interface {
+	// Publish 向频道发布消息
+	Publish(channel string, data []byte) error
+	// Subscribe 订阅频道，返回的通道在Close后关闭
+	Subscribe(channels ...string) (<-chan []byte, error)
+	// SetPresence 登记节点上的服务器及其协商的能力，ttl后未刷新视为离线
+	SetPresence(nodeID string, servers map[string][]string, ttl time.Duration) error
+	// RemovePresence 删除节点上的服务器
+	RemovePresence(nodeID string, serverIDs []string) error
+	// Presence 获取所有节点上未过期的服务器，按服务器ID分组
+	Presence() (map[string][]Member, error)
+	// Close 取消订阅并关闭连接
+	Close() error
+}
+
+// Member 连接在某个节点上的服务器
+type Member struct {
+	NodeID       string   `json:"nodeId"`
+	Capabilities []string `json:"capabilities,omitempty"` // 该服务器各会话协商的能力合集
+}
+
+// presenceValue 在线信息中保存的值
+type presenceValue struct {
+	ExpiresAt    int64    `json:"expiresAt"` // 毫秒时间戳
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// presenceField 在线信息中的字段名，节点ID不包含'/'
+func presenceField(nodeID, serverID string) string {
+	return nodeID + "/" + serverID
+}
+
+// parsePresenceField 解析在线信息中的字段名
+func parsePresenceField(field string) (nodeID, serverID string, ok bool) {
+	i := strings.Index(field, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return field[:i], field[i+1:], true
+}
+
+// RedisTransport 基于Redis发布订阅的节点通道
+//
+// 在线信息保存在哈希 cluster:presence 中，字段为 {节点ID}/{服务器ID}，值为过期时间和能力（JSON），
+// 读取时删除已过期的字段，节点异常退出后其登记的服务器会在过期后自动失效
+type RedisTransport struct {
+	client *redis.Client
+	ctx    context.Context
+	prefix string
+	pubsub *redis.PubSub
+	mu     sync.Mutex
+}
+
+// NewRedisTransport 创建Redis节点通道，prefix为所有键和频道的前缀
+func NewRedisTransport(addr, password string, db int, prefix string) (*RedisTransport, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx := context.Background()
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("连接集群Redis失败: %v", err)
+	}
+
+	return &RedisTransport{
+		client: client,
+		ctx:    ctx,
+		prefix: prefix,
+	}, nil
+}
+
+// presenceKey 在线信息哈希的键
+func (t *RedisTransport) presenceKey() string {
+	return t.prefix + "cluster:presence"
+}
+
+// Publish 向频道发布消息
+func (t *RedisTransport) Publish(channel string, data []byte) error {
+	return t.client.Publish(t.ctx, t.prefix+channel, data).Err()
+}
+
+// Subscribe 订阅频道，确认订阅成功后返回
+func (t *RedisTransport) Subscribe(channels ...string) (<-chan []byte, error) {
+	prefixed := make([]string, len(channels))
+	for i, channel := range channels {
+		prefixed[i] = t.prefix + channel
+	}
+
+	pubsub := t.client.Subscribe(t.ctx, prefixed...)
+	if _, err := pubsub.Receive(t.ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("订阅集群频道失败: %v", err)
+	}
+
+	t.mu.Lock()
+	t.pubsub = pubsub
+	t.mu.Unlock()
+
+	out := make(chan []byte, 256)
+	go func() {
+		defer close(out)
+		for msg := range pubsub.Channel() {
+			out <- []byte(msg.Payload)
+		}
+	}()
+	return out, nil
+}
+
+// SetPresence 登记节点上的服务器
+func (t *RedisTransport) SetPresence(nodeID string, servers map[string][]string, ttl time.Duration) error {
+	if len(servers) == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(ttl).UnixMilli()
+	values := make([]interface{}, 0, len(servers)*2)
+	for serverID, capabilities := range servers {
+		data, err := json.Marshal(presenceValue{ExpiresAt: expiresAt, Capabilities: capabilities})
+		if err != nil {
+			return err
+		}
+		values = append(values, presenceField(nodeID, serverID), data)
+	}
+	return t.client.HSet(t.ctx, t.presenceKey(), values...).Err()
+}
+
+// RemovePresence 删除节点上的服务器
+func (t *RedisTransport) RemovePresence(nodeID string, serverIDs []string) error {
+	if len(serverIDs) == 0 {
+		return nil
+	}
+	fields := make([]string, len(serverIDs))
+	for i, serverID := range serverIDs {
+		fields[i] = presenceField(nodeID, serverID)
+	}
+	return t.client.HDel(t.ctx, t.presenceKey(), fields...).Err()
+}
+
+// Presence 获取所有节点上未过期的服务器
+func (t *RedisTransport) Presence() (map[string][]Member, error) {
+	values, err := t.client.HGetAll(t.ctx, t.presenceKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	presence := make(map[string][]Member)
+	var expired []string
+	for field, data := range values {
+		var value presenceValue
+		nodeID, serverID, ok := parsePresenceField(field)
+		if !ok || json.Unmarshal([]byte(data), &value) != nil || value.ExpiresAt < now {
+			expired = append(expired, field)
+			continue
+		}
+		presence[serverID] = append(presence[serverID], Member{NodeID: nodeID, Capabilities: value.Capabilities})
+	}
+	if len(expired) > 0 {
+		t.client.HDel(t.ctx, t.presenceKey(), expired...)
+	}
+	return presence, nil
+}
+
+// Close 取消订阅并关闭连接
+func (t *RedisTransport) Close() error {
+	t.mu.Lock()
+	if t.pubsub != nil {
+		t.pubsub.Close()
+	}
+	t.mu.Unlock()
+	return t.client.Close()
+}
+
+// MemoryHub 进程内的节点通道，多个节点共用同一个MemoryHub时相当于共用一个Redis，用于测试和单进程调试
+type MemoryHub struct {
+	subscribers map[string][]chan []byte
+	presence    map[string]presenceValue // 字段同RedisTransport
+	mu          sync.Mutex
+}
+
+// NewMemoryHub 创建进程内的节点通道
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{
+		subscribers: make(map[string][]chan []byte),
+		presence:    make(map[string]presenceValue),
+	}
+}
+
+// Transport 为一个节点创建连接到该MemoryHub的通道
+func (h *MemoryHub) Transport() Transport {
+	return &memoryTransport{hub: h}
+}
+
+// memoryTransport 连接到MemoryHub的节点通道
+type memoryTransport struct {
+	hub      *MemoryHub
+	channels []string
+	out      chan []byte
+	closed   bool
+}
+
+// Publish 向频道发布消息，订阅者的缓冲区已满时丢弃
+func (t *memoryTransport) Publish(channel string, data []byte) error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	for _, out := range t.hub.subscribers[channel] {
+		select {
+		case out <- append([]byte(nil), data...):
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe 订阅频道
+func (t *memoryTransport) Subscribe(channels ...string) (<-chan []byte, error) {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	t.out = make(chan []byte, 256)
+	t.channels = channels
+	for _, channel := range channels {
+		t.hub.subscribers[channel] = append(t.hub.subscribers[channel], t.out)
+	}
+	return t.out, nil
+}
+
+// SetPresence 登记节点上的服务器
+func (t *memoryTransport) SetPresence(nodeID string, servers map[string][]string, ttl time.Duration) error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl).UnixMilli()
+	for serverID, capabilities := range servers {
+		t.hub.presence[presenceField(nodeID, serverID)] = presenceValue{ExpiresAt: expiresAt, Capabilities: capabilities}
+	}
+	return nil
+}
+
+// RemovePresence 删除节点上的服务器
+func (t *memoryTransport) RemovePresence(nodeID string, serverIDs []string) error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	for _, serverID := range serverIDs {
+		delete(t.hub.presence, presenceField(nodeID, serverID))
+	}
+	return nil
+}
+
+// Presence 获取所有节点上未过期的服务器
+func (t *memoryTransport) Presence() (map[string][]Member, error) {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	presence := make(map[string][]Member)
+	for field, value := range t.hub.presence {
+		nodeID, serverID, _ := parsePresenceField(field)
+		if value.ExpiresAt < now {
+			delete(t.hub.presence, field)
+			continue
+		}
+		presence[serverID] = append(presence[serverID], Member{NodeID: nodeID, Capabilities: value.Capabilities})
+	}
+	return presence, nil
+}
+
+// Close 取消订阅
+func (t *memoryTransport) Close() error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+
+	if t.closed || t.out == nil {
+		t.closed = true
+		return nil
+	}
+	t.closed = true
+	for _, channel := range t.channels {
+		subscribers := t.hub.subscribers[channel]
+		for i, out := range subscribers {
+			if out == t.out {
+				t.hub.subscribers[channel] = append(subscribers[:i], subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+	close(t.out)
+	return nil
+}
@@ -3,56 +3,172 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"GRUniChat-Broadcaster/internal/config"
+
 	"github.com/redis/go-redis/v9"
 )
 
 // MessageStoreInterface 消息存储接口
 type MessageStoreInterface interface {
 	StoreMessage(messageID string, message []byte, ttl time.Duration) error
+	StoreMessageWithStatus(messageID string, message []byte, status string, ttl time.Duration) error
 	GetMessage(messageID string) ([]byte, error)
 	DeleteMessage(messageID string) error
 	SetMessageStatus(messageID, status string, ttl time.Duration) error
 	GetMessageStatus(messageID string) (string, error)
 	IncrementCounter(key string) (int64, error)
+	AddCounter(key string, delta int64) (int64, error)
+	GetCounter(key string) (int64, error)
+	ListCounters(prefix string) (map[string]int64, error)
+	EnqueueOffline(target string, message []byte, ttl time.Duration, maxSize int) error
+	DequeueOffline(target string) ([][]byte, error)
+	SetDeliveryStatus(messageID, target, status string, ttl time.Duration) error
+	GetDeliveryStatuses(messageID string) (map[string]string, error)
+	SetModerationRecord(messageID, group string, record []byte, ttl time.Duration) error
+	GetModerationRecords(messageID string) (map[string][]byte, error)
+	AppendHistory(entry HistoryEntry, ttl time.Duration, maxEntries int) error
+	QueryHistory(query HistoryQuery) ([]HistoryEntry, error)
 	GetStats() (map[string]interface{}, error)
 	Close() error
 }
 
+// offlineEntry 离线队列中的一条消息
+type offlineEntry struct {
+	Data      []byte    `json:"data"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// expired 检查离线消息是否已过期
+func (e offlineEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// newOfflineEntry 创建离线消息，ttl<=0表示不过期
+func newOfflineEntry(message []byte, ttl time.Duration) offlineEntry {
+	entry := offlineEntry{Data: message}
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(ttl)
+	}
+	return entry
+}
+
+// 内存存储中各类数据在LRU缓存里的类别
+const (
+	memoryKindMessage    = "msg"
+	memoryKindStatus     = "status"
+	memoryKindDelivery   = "delivery"
+	memoryKindModeration = "moderation"
+)
+
 // MemoryStore 内存消息存储
+//
+// 消息、状态、投递记录和审核记录共用一个LRU缓存，按TTL过期并受条数和字节数上限约束，
+// 后台清理协程定期删除过期的数据。
 type MemoryStore struct {
-	messages map[string][]byte
-	statuses map[string]string
-	counters map[string]int64
-	mutex    sync.RWMutex
+	cache      *lruCache
+	counters   map[string]int64
+	offline    map[string][]offlineEntry
+	history    []historyRecord
+	historyIDs map[string]struct{} // history中的消息ID，重复的totalId只记录第一条
+	stop       chan struct{}
+	stopOnce   sync.Once
+	mutex      sync.RWMutex
 }
 
-// NewMemoryStore 创建内存存储实例
-func NewMemoryStore() MessageStoreInterface {
-	return &MemoryStore{
-		messages: make(map[string][]byte),
-		statuses: make(map[string]string),
-		counters: make(map[string]int64),
+// NewMemoryStore 创建内存存储实例并启动过期数据清理
+func NewMemoryStore(cfg *config.MemoryConfig) MessageStoreInterface {
+	ms := &MemoryStore{
+		cache:      newLRUCache(cfg.MaxEntries, cfg.MaxBytes),
+		counters:   make(map[string]int64),
+		offline:    make(map[string][]offlineEntry),
+		historyIDs: make(map[string]struct{}),
+		stop:       make(chan struct{}),
+	}
+	go ms.janitor(time.Duration(cfg.CleanupInterval) * time.Second)
+	return ms
+}
+
+// janitor 定期清理过期的数据
+func (ms *MemoryStore) janitor(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ms.removeExpired(time.Now())
+		case <-ms.stop:
+			return
+		}
 	}
 }
 
+// removeExpired 删除过期的消息、状态、投递记录、离线消息和历史消息
+func (ms *MemoryStore) removeExpired(now time.Time) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.cache.removeExpired(now)
+
+	for target, queue := range ms.offline {
+		kept := queue[:0]
+		for _, entry := range queue {
+			if !entry.expired(now) {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ms.offline, target)
+		} else {
+			ms.offline[target] = kept
+		}
+	}
+
+	kept := ms.history[:0]
+	for _, record := range ms.history {
+		if record.expiresAt.IsZero() || now.Before(record.expiresAt) {
+			kept = append(kept, record)
+		} else {
+			delete(ms.historyIDs, record.entry.MessageID)
+		}
+	}
+	ms.history = kept
+}
+
 // StoreMessage 存储消息
 func (ms *MemoryStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	ms.messages[messageID] = message
+	ms.cache.set(memoryKindMessage, messageID, message, ttl, time.Now())
+	return nil
+}
+
+// StoreMessageWithStatus 存储消息并设置状态
+func (ms *MemoryStore) StoreMessageWithStatus(messageID string, message []byte, status string, ttl time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	now := time.Now()
+	ms.cache.set(memoryKindMessage, messageID, message, ttl, now)
+	ms.cache.set(memoryKindStatus, messageID, []byte(status), ttl, now)
 	return nil
 }
 
 // GetMessage 获取消息
 func (ms *MemoryStore) GetMessage(messageID string) ([]byte, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 
-	if msg, exists := ms.messages[messageID]; exists {
+	if msg, exists := ms.cache.get(memoryKindMessage, messageID, time.Now()); exists {
 		return msg, nil
 	}
 	return nil, fmt.Errorf("消息不存在")
@@ -62,7 +178,7 @@ func (ms *MemoryStore) GetMessage(messageID string) ([]byte, error) {
 func (ms *MemoryStore) DeleteMessage(messageID string) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	delete(ms.messages, messageID)
+	ms.cache.delete(memoryKindMessage, messageID)
 	return nil
 }
 
@@ -70,56 +186,215 @@ func (ms *MemoryStore) DeleteMessage(messageID string) error {
 func (ms *MemoryStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	ms.statuses[messageID] = status
+	ms.cache.set(memoryKindStatus, messageID, []byte(status), ttl, time.Now())
 	return nil
 }
 
 // GetMessageStatus 获取消息状态
 func (ms *MemoryStore) GetMessageStatus(messageID string) (string, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 
-	if status, exists := ms.statuses[messageID]; exists {
-		return status, nil
+	if status, exists := ms.cache.get(memoryKindStatus, messageID, time.Now()); exists {
+		return string(status), nil
 	}
 	return "", fmt.Errorf("状态不存在")
 }
 
 // IncrementCounter 递增计数器
 func (ms *MemoryStore) IncrementCounter(key string) (int64, error) {
+	return ms.AddCounter(key, 1)
+}
+
+// AddCounter 将计数器增加delta
+func (ms *MemoryStore) AddCounter(key string, delta int64) (int64, error) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	ms.counters[key]++
+	ms.counters[key] += delta
+	return ms.counters[key], nil
+}
+
+// GetCounter 获取计数器的值，不存在时为0
+func (ms *MemoryStore) GetCounter(key string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
 	return ms.counters[key], nil
 }
 
+// ListCounters 获取名称以prefix开头的全部计数器
+func (ms *MemoryStore) ListCounters(prefix string) (map[string]int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	counters := make(map[string]int64)
+	for key, value := range ms.counters {
+		if strings.HasPrefix(key, prefix) {
+			counters[key] = value
+		}
+	}
+	return counters, nil
+}
+
+// EnqueueOffline 为离线目标缓存消息，超出maxSize时丢弃最旧的消息
+func (ms *MemoryStore) EnqueueOffline(target string, message []byte, ttl time.Duration, maxSize int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	queue := append(ms.offline[target], newOfflineEntry(message, ttl))
+	if maxSize > 0 && len(queue) > maxSize {
+		queue = queue[len(queue)-maxSize:]
+	}
+	ms.offline[target] = queue
+	return nil
+}
+
+// DequeueOffline 按入队顺序取出目标的全部未过期离线消息
+func (ms *MemoryStore) DequeueOffline(target string) ([][]byte, error) {
+	ms.mutex.Lock()
+	queue := ms.offline[target]
+	delete(ms.offline, target)
+	ms.mutex.Unlock()
+
+	now := time.Now()
+	messages := make([][]byte, 0, len(queue))
+	for _, entry := range queue {
+		if !entry.expired(now) {
+			messages = append(messages, entry.Data)
+		}
+	}
+	return messages, nil
+}
+
+// SetDeliveryStatus 设置消息在某个目标上的投递状态
+func (ms *MemoryStore) SetDeliveryStatus(messageID, target, status string, ttl time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	now := time.Now()
+	statuses := make(map[string]string)
+	if data, exists := ms.cache.get(memoryKindDelivery, messageID, now); exists {
+		json.Unmarshal(data, &statuses)
+	}
+	statuses[target] = status
+
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	ms.cache.set(memoryKindDelivery, messageID, data, ttl, now)
+	return nil
+}
+
+// GetDeliveryStatuses 获取消息在各目标上的投递状态
+func (ms *MemoryStore) GetDeliveryStatuses(messageID string) (map[string]string, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	data, exists := ms.cache.get(memoryKindDelivery, messageID, time.Now())
+	if !exists {
+		return nil, fmt.Errorf("投递状态不存在")
+	}
+
+	var statuses map[string]string
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// SetModerationRecord 保存消息在某个目标群组上的内容审核记录
+func (ms *MemoryStore) SetModerationRecord(messageID, group string, record []byte, ttl time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	now := time.Now()
+	records := make(map[string][]byte)
+	if data, exists := ms.cache.get(memoryKindModeration, messageID, now); exists {
+		json.Unmarshal(data, &records)
+	}
+	records[group] = record
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	ms.cache.set(memoryKindModeration, messageID, data, ttl, now)
+	return nil
+}
+
+// GetModerationRecords 获取消息在各目标群组上的内容审核记录，没有记录时返回空map
+func (ms *MemoryStore) GetModerationRecords(messageID string) (map[string][]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	records := make(map[string][]byte)
+	data, exists := ms.cache.get(memoryKindModeration, messageID, time.Now())
+	if !exists {
+		return records, nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // GetStats 获取统计信息
 func (ms *MemoryStore) GetStats() (map[string]interface{}, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
+	offlineMessages := 0
+	for _, queue := range ms.offline {
+		offlineMessages += len(queue)
+	}
+
 	stats := make(map[string]interface{})
 	stats["type"] = "memory"
-	stats["stored_messages"] = len(ms.messages)
-	stats["message_statuses"] = len(ms.statuses)
+	stats["stored_messages"] = ms.cache.count(memoryKindMessage)
+	stats["message_statuses"] = ms.cache.count(memoryKindStatus)
+	stats["delivery_records"] = ms.cache.count(memoryKindDelivery)
+	stats["moderation_records"] = ms.cache.count(memoryKindModeration)
 	stats["counters"] = len(ms.counters)
+	stats["offline_messages"] = offlineMessages
+	stats["history_messages"] = len(ms.history)
+	stats["cache_entries"] = ms.cache.order.Len()
+	stats["cache_bytes"] = ms.cache.bytes
+	stats["max_entries"] = ms.cache.maxEntries
+	stats["max_bytes"] = ms.cache.maxBytes
+	stats["evictions"] = ms.cache.evictions
+	stats["expirations"] = ms.cache.expirations
 
 	return stats, nil
 }
 
-// Close 关闭存储
+// Close 停止过期数据清理
 func (ms *MemoryStore) Close() error {
+	ms.stopOnce.Do(func() {
+		close(ms.stop)
+	})
 	return nil
 }
 
+// Redis中的统计数据：累计写入次数保存在 stats 哈希中，
+// 每小时写入的消息ID记录在 stats:messages:{小时} 的HyperLogLog中，用于估算近24小时的消息数，
+// IncrementCounter 的计数器保存在 counters 哈希中，统计和列出计数器时都不需要遍历键空间
+const (
+	redisStatsKey          = "stats"
+	redisCountersKey       = "counters"
+	redisMessagesHourKey   = "stats:messages:%s"
+	redisMessagesHourLimit = 24
+	redisStatsHourLayout   = "2006010215"
+)
+
 // RedisStore Redis消息存储
 type RedisStore struct {
 	client *redis.Client
 	ctx    context.Context
+	prefix string // 所有键的前缀
 }
 
-// NewRedisStore 创建Redis存储实例
-func NewRedisStore(addr, password string, db int) (MessageStoreInterface, error) {
+// NewRedisStore 创建Redis存储实例，prefix为所有键的前缀
+func NewRedisStore(addr, password string, db int, prefix string) (MessageStoreInterface, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -135,65 +410,254 @@ func NewRedisStore(addr, password string, db int) (MessageStoreInterface, error)
 	return &RedisStore{
 		client: client,
 		ctx:    ctx,
+		prefix: prefix,
 	}, nil
 }
 
+// key 生成带前缀的键
+func (rs *RedisStore) key(format string, args ...interface{}) string {
+	return rs.prefix + fmt.Sprintf(format, args...)
+}
+
+// queueStoreMessage 将存储消息及其统计加入管道
+func (rs *RedisStore) queueStoreMessage(pipe redis.Pipeliner, messageID string, message []byte, ttl time.Duration) {
+	hourKey := rs.key(redisMessagesHourKey, time.Now().Format(redisStatsHourLayout))
+	pipe.Set(rs.ctx, rs.key("msg:%s", messageID), message, ttl)
+	pipe.HIncrBy(rs.ctx, rs.key(redisStatsKey), "messages_stored", 1)
+	pipe.PFAdd(rs.ctx, hourKey, messageID)
+	pipe.Expire(rs.ctx, hourKey, (redisMessagesHourLimit+1)*time.Hour)
+}
+
+// queueSetStatus 将设置消息状态及其统计加入管道
+func (rs *RedisStore) queueSetStatus(pipe redis.Pipeliner, messageID, status string, ttl time.Duration) {
+	pipe.Set(rs.ctx, rs.key("status:%s", messageID), status, ttl)
+	pipe.HIncrBy(rs.ctx, rs.key(redisStatsKey), "status_updates", 1)
+}
+
 // StoreMessage 存储消息
 func (rs *RedisStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return rs.client.Set(rs.ctx, key, message, ttl).Err()
+	_, err := rs.client.Pipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		rs.queueStoreMessage(pipe, messageID, message, ttl)
+		return nil
+	})
+	return err
+}
+
+// StoreMessageWithStatus 存储消息并设置状态，在一次往返中完成
+func (rs *RedisStore) StoreMessageWithStatus(messageID string, message []byte, status string, ttl time.Duration) error {
+	_, err := rs.client.Pipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		rs.queueStoreMessage(pipe, messageID, message, ttl)
+		rs.queueSetStatus(pipe, messageID, status, ttl)
+		return nil
+	})
+	return err
 }
 
 // GetMessage 获取消息
 func (rs *RedisStore) GetMessage(messageID string) ([]byte, error) {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return rs.client.Get(rs.ctx, key).Bytes()
+	return rs.client.Get(rs.ctx, rs.key("msg:%s", messageID)).Bytes()
 }
 
 // DeleteMessage 删除消息
 func (rs *RedisStore) DeleteMessage(messageID string) error {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return rs.client.Del(rs.ctx, key).Err()
+	return rs.client.Del(rs.ctx, rs.key("msg:%s", messageID)).Err()
 }
 
 // SetMessageStatus 设置消息状态
 func (rs *RedisStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
-	key := fmt.Sprintf("status:%s", messageID)
-	return rs.client.Set(rs.ctx, key, status, ttl).Err()
+	_, err := rs.client.Pipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		rs.queueSetStatus(pipe, messageID, status, ttl)
+		return nil
+	})
+	return err
 }
 
 // GetMessageStatus 获取消息状态
 func (rs *RedisStore) GetMessageStatus(messageID string) (string, error) {
-	key := fmt.Sprintf("status:%s", messageID)
-	return rs.client.Get(rs.ctx, key).Result()
+	return rs.client.Get(rs.ctx, rs.key("status:%s", messageID)).Result()
 }
 
 // IncrementCounter 递增计数器
 func (rs *RedisStore) IncrementCounter(key string) (int64, error) {
-	return rs.client.Incr(rs.ctx, key).Result()
+	return rs.AddCounter(key, 1)
 }
 
-// GetStats 获取Redis统计信息
-func (rs *RedisStore) GetStats() (map[string]interface{}, error) {
-	info, err := rs.client.Info(rs.ctx, "memory", "keyspace", "stats").Result()
+// AddCounter 将计数器增加delta
+func (rs *RedisStore) AddCounter(key string, delta int64) (int64, error) {
+	return rs.client.HIncrBy(rs.ctx, rs.key(redisCountersKey), key, delta).Result()
+}
+
+// GetCounter 获取计数器的值，不存在时为0
+func (rs *RedisStore) GetCounter(key string) (int64, error) {
+	value, err := rs.client.HGet(rs.ctx, rs.key(redisCountersKey), key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return value, err
+}
+
+// ListCounters 获取名称以prefix开头的全部计数器
+func (rs *RedisStore) ListCounters(prefix string) (map[string]int64, error) {
+	values, err := rs.client.HGetAll(rs.ctx, rs.key(redisCountersKey)).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	stats := make(map[string]interface{})
-	stats["type"] = "redis"
-	stats["redis_info"] = info
+	counters := make(map[string]int64)
+	for key, value := range values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[key] = count
+	}
+	return counters, nil
+}
+
+// EnqueueOffline 为离线目标缓存消息，超出maxSize时丢弃最旧的消息
+func (rs *RedisStore) EnqueueOffline(target string, message []byte, ttl time.Duration, maxSize int) error {
+	data, err := json.Marshal(newOfflineEntry(message, ttl))
+	if err != nil {
+		return err
+	}
+
+	key := rs.key("offline:%s", target)
+	_, err = rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(rs.ctx, key, data)
+		if maxSize > 0 {
+			pipe.LTrim(rs.ctx, key, int64(-maxSize), -1)
+		}
+		if ttl > 0 {
+			pipe.Expire(rs.ctx, key, ttl)
+		}
+		pipe.HIncrBy(rs.ctx, rs.key(redisStatsKey), "offline_enqueued", 1)
+		return nil
+	})
+	return err
+}
+
+// DequeueOffline 按入队顺序取出目标的全部未过期离线消息
+func (rs *RedisStore) DequeueOffline(target string) ([][]byte, error) {
+	key := rs.key("offline:%s", target)
 
-	// 获取消息相关的键数量
-	msgKeys, err := rs.client.Keys(rs.ctx, "msg:*").Result()
-	if err == nil {
-		stats["stored_messages"] = len(msgKeys)
+	var rangeCmd *redis.StringSliceCmd
+	_, err := rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		rangeCmd = pipe.LRange(rs.ctx, key, 0, -1)
+		pipe.Del(rs.ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	statusKeys, err := rs.client.Keys(rs.ctx, "status:*").Result()
-	if err == nil {
-		stats["message_statuses"] = len(statusKeys)
+	now := time.Now()
+	var messages [][]byte
+	for _, item := range rangeCmd.Val() {
+		var entry offlineEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			continue
+		}
+		if !entry.expired(now) {
+			messages = append(messages, entry.Data)
+		}
 	}
+	return messages, nil
+}
+
+// SetDeliveryStatus 设置消息在某个目标上的投递状态
+func (rs *RedisStore) SetDeliveryStatus(messageID, target, status string, ttl time.Duration) error {
+	key := rs.key("delivery:%s", messageID)
+	_, err := rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(rs.ctx, key, target, status)
+		if ttl > 0 {
+			pipe.Expire(rs.ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetDeliveryStatuses 获取消息在各目标上的投递状态
+func (rs *RedisStore) GetDeliveryStatuses(messageID string) (map[string]string, error) {
+	statuses, err := rs.client.HGetAll(rs.ctx, rs.key("delivery:%s", messageID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, fmt.Errorf("投递状态不存在")
+	}
+	return statuses, nil
+}
+
+// SetModerationRecord 保存消息在某个目标群组上的内容审核记录
+func (rs *RedisStore) SetModerationRecord(messageID, group string, record []byte, ttl time.Duration) error {
+	key := rs.key("moderation:%s", messageID)
+	_, err := rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(rs.ctx, key, group, record)
+		if ttl > 0 {
+			pipe.Expire(rs.ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetModerationRecords 获取消息在各目标群组上的内容审核记录，没有记录时返回空map
+func (rs *RedisStore) GetModerationRecords(messageID string) (map[string][]byte, error) {
+	values, err := rs.client.HGetAll(rs.ctx, rs.key("moderation:%s", messageID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string][]byte, len(values))
+	for group, record := range values {
+		records[group] = []byte(record)
+	}
+	return records, nil
+}
+
+// GetStats 获取Redis统计信息，只读取计数器，不遍历键空间
+func (rs *RedisStore) GetStats() (map[string]interface{}, error) {
+	now := time.Now()
+	hourKeys := make([]string, redisMessagesHourLimit)
+	for i := range hourKeys {
+		hourKeys[i] = rs.key(redisMessagesHourKey, now.Add(-time.Duration(i)*time.Hour).Format(redisStatsHourLayout))
+	}
+
+	var infoCmd *redis.StringCmd
+	var countersCmd *redis.MapStringStringCmd
+	var recentCmd, historyCmd, counterCountCmd *redis.IntCmd
+	rs.client.Pipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		infoCmd = pipe.Info(rs.ctx, "memory", "keyspace", "stats")
+		countersCmd = pipe.HGetAll(rs.ctx, rs.key(redisStatsKey))
+		recentCmd = pipe.PFCount(rs.ctx, hourKeys...)
+		historyCmd = pipe.ZCard(rs.ctx, rs.historyAllKey())
+		counterCountCmd = pipe.HLen(rs.ctx, rs.key(redisCountersKey))
+		return nil
+	})
+	// INFO只作参考，旧版本Redis不支持同时指定多个部分，失败时忽略
+	for _, cmd := range []redis.Cmder{countersCmd, recentCmd, historyCmd, counterCountCmd} {
+		if err := cmd.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := make(map[string]interface{})
+	stats["type"] = "redis"
+	stats["key_prefix"] = rs.prefix
+	if infoCmd.Err() == nil {
+		stats["redis_info"] = infoCmd.Val()
+	}
+	for _, field := range []string{"messages_stored", "status_updates", "offline_enqueued"} {
+		count, _ := strconv.ParseInt(countersCmd.Val()[field], 10, 64)
+		stats[field] = count
+	}
+	stats["messages_last_24h"] = recentCmd.Val() // HyperLogLog估算值，误差约0.81%
+	stats["history_messages"] = historyCmd.Val()
+	stats["counters"] = counterCountCmd.Val()
 
 	return stats, nil
 }
@@ -203,16 +667,18 @@ func (rs *RedisStore) Close() error {
 	return rs.client.Close()
 }
 
-// SQLStore SQL数据库存储（MySQL/PostgreSQL）
+// SQLStore SQL数据库存储（MySQL/PostgreSQL/SQLite）
 type SQLStore struct {
 	db       *sql.DB
 	dbType   string
-	counters map[string]int64
+	purge    purgeStats
+	stop     chan struct{}
+	stopOnce sync.Once
 	mutex    sync.RWMutex
 }
 
-// NewSQLStore 创建SQL存储实例
-func NewSQLStore(dbType, dsn string) (MessageStoreInterface, error) {
+// NewSQLStore 创建SQL存储实例，迁移表结构并启动过期数据清理
+func NewSQLStore(dbType, dsn string, purge *config.PurgeConfig) (MessageStoreInterface, error) {
 	db, err := sql.Open(dbType, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("连接数据库失败: %v", err)
@@ -223,70 +689,50 @@ func NewSQLStore(dbType, dsn string) (MessageStoreInterface, error) {
 	}
 
 	store := &SQLStore{
-		db:       db,
-		dbType:   dbType,
-		counters: make(map[string]int64),
+		db:     db,
+		dbType: dbType,
+		stop:   make(chan struct{}),
 	}
 
-	// 初始化表结构
-	if err := store.initTables(); err != nil {
+	// 按版本迁移表结构
+	if err := store.migrate(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("初始化表结构失败: %v", err)
 	}
 
+	go store.purgeLoop(time.Duration(purge.Interval)*time.Second, purge.BatchSize)
 	return store, nil
 }
 
-// initTables 初始化数据库表
-func (ss *SQLStore) initTables() error {
-	// 消息表
-	createMessagesTable := `
-		CREATE TABLE IF NOT EXISTS ws_messages (
-			id VARCHAR(255) PRIMARY KEY,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			expires_at TIMESTAMP
-		)`
-
-	// 状态表
-	createStatusTable := `
-		CREATE TABLE IF NOT EXISTS ws_message_status (
-			message_id VARCHAR(255) PRIMARY KEY,
-			status VARCHAR(50) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			expires_at TIMESTAMP
-		)`
-
-	// PostgreSQL使用不同的语法
-	if ss.dbType == "postgres" {
-		createMessagesTable = `
-			CREATE TABLE IF NOT EXISTS ws_messages (
-				id VARCHAR(255) PRIMARY KEY,
-				content TEXT NOT NULL,
-				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				expires_at TIMESTAMP
-			)`
-		createStatusTable = `
-			CREATE TABLE IF NOT EXISTS ws_message_status (
-				message_id VARCHAR(255) PRIMARY KEY,
-				status VARCHAR(50) NOT NULL,
-				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				expires_at TIMESTAMP
-			)`
-	}
-
-	if _, err := ss.db.Exec(createMessagesTable); err != nil {
+// sqlExecer *sql.DB 和 *sql.Tx 共有的执行方法
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// StoreMessage 存储消息
+func (ss *SQLStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
+	return ss.storeMessage(ss.db, messageID, message, ttl)
+}
+
+// StoreMessageWithStatus 存储消息并设置状态，在同一个事务中完成
+func (ss *SQLStore) StoreMessageWithStatus(messageID string, message []byte, status string, ttl time.Duration) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	if _, err := ss.db.Exec(createStatusTable); err != nil {
+	if err := ss.storeMessage(tx, messageID, message, ttl); err != nil {
 		return err
 	}
-
-	return nil
+	if err := ss.setMessageStatus(tx, messageID, status, ttl); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// StoreMessage 存储消息
-func (ss *SQLStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
+// storeMessage 写入或更新消息
+func (ss *SQLStore) storeMessage(exec sqlExecer, messageID string, message []byte, ttl time.Duration) error {
 	var expiresAt *time.Time
 	if ttl > 0 {
 		expiry := time.Now().Add(ttl)
@@ -296,21 +742,22 @@ func (ss *SQLStore) StoreMessage(messageID string, message []byte, ttl time.Dura
 	query := `INSERT INTO ws_messages (id, content, expires_at) VALUES (?, ?, ?) 
 			  ON DUPLICATE KEY UPDATE content = VALUES(content), expires_at = VALUES(expires_at)`
 
-	if ss.dbType == "postgres" {
+	switch ss.dbType {
+	case "postgres":
 		query = `INSERT INTO ws_messages (id, content, expires_at) VALUES ($1, $2, $3) 
 				 ON CONFLICT (id) DO UPDATE SET content = EXCLUDED.content, expires_at = EXCLUDED.expires_at`
+	case "sqlite3":
+		query = `INSERT INTO ws_messages (id, content, expires_at) VALUES (?, ?, ?)
+				 ON CONFLICT (id) DO UPDATE SET content = excluded.content, expires_at = excluded.expires_at`
 	}
 
-	_, err := ss.db.Exec(query, messageID, string(message), expiresAt)
+	_, err := exec.Exec(query, messageID, string(message), ss.timeArg(expiresAt))
 	return err
 }
 
 // GetMessage 获取消息
 func (ss *SQLStore) GetMessage(messageID string) ([]byte, error) {
-	query := `SELECT content FROM ws_messages WHERE id = ? AND (expires_at IS NULL OR expires_at > NOW())`
-	if ss.dbType == "postgres" {
-		query = `SELECT content FROM ws_messages WHERE id = $1 AND (expires_at IS NULL OR expires_at > NOW())`
-	}
+	query := ss.rebind(`SELECT content FROM ws_messages WHERE id = ? AND (expires_at IS NULL OR expires_at > NOW())`)
 
 	var content string
 	err := ss.db.QueryRow(query, messageID).Scan(&content)
@@ -337,6 +784,11 @@ func (ss *SQLStore) DeleteMessage(messageID string) error {
 
 // SetMessageStatus 设置消息状态
 func (ss *SQLStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
+	return ss.setMessageStatus(ss.db, messageID, status, ttl)
+}
+
+// setMessageStatus 写入或更新消息状态
+func (ss *SQLStore) setMessageStatus(exec sqlExecer, messageID, status string, ttl time.Duration) error {
 	var expiresAt *time.Time
 	if ttl > 0 {
 		expiry := time.Now().Add(ttl)
@@ -346,21 +798,22 @@ func (ss *SQLStore) SetMessageStatus(messageID, status string, ttl time.Duration
 	query := `INSERT INTO ws_message_status (message_id, status, expires_at) VALUES (?, ?, ?) 
 			  ON DUPLICATE KEY UPDATE status = VALUES(status), expires_at = VALUES(expires_at)`
 
-	if ss.dbType == "postgres" {
+	switch ss.dbType {
+	case "postgres":
 		query = `INSERT INTO ws_message_status (message_id, status, expires_at) VALUES ($1, $2, $3) 
 				 ON CONFLICT (message_id) DO UPDATE SET status = EXCLUDED.status, expires_at = EXCLUDED.expires_at`
+	case "sqlite3":
+		query = `INSERT INTO ws_message_status (message_id, status, expires_at) VALUES (?, ?, ?)
+				 ON CONFLICT (message_id) DO UPDATE SET status = excluded.status, expires_at = excluded.expires_at`
 	}
 
-	_, err := ss.db.Exec(query, messageID, status, expiresAt)
+	_, err := exec.Exec(query, messageID, status, ss.timeArg(expiresAt))
 	return err
 }
 
 // GetMessageStatus 获取消息状态
 func (ss *SQLStore) GetMessageStatus(messageID string) (string, error) {
-	query := `SELECT status FROM ws_message_status WHERE message_id = ? AND (expires_at IS NULL OR expires_at > NOW())`
-	if ss.dbType == "postgres" {
-		query = `SELECT status FROM ws_message_status WHERE message_id = $1 AND (expires_at IS NULL OR expires_at > NOW())`
-	}
+	query := ss.rebind(`SELECT status FROM ws_message_status WHERE message_id = ? AND (expires_at IS NULL OR expires_at > NOW())`)
 
 	var status string
 	err := ss.db.QueryRow(query, messageID).Scan(&status)
@@ -374,12 +827,247 @@ func (ss *SQLStore) GetMessageStatus(messageID string) (string, error) {
 	return status, nil
 }
 
-// IncrementCounter 递增计数器
+// IncrementCounter 在 ws_counters 表中原子递增计数器，重启后保留，多个实例共用数据库时共享
 func (ss *SQLStore) IncrementCounter(key string) (int64, error) {
-	ss.mutex.Lock()
-	defer ss.mutex.Unlock()
-	ss.counters[key]++
-	return ss.counters[key], nil
+	return ss.AddCounter(key, 1)
+}
+
+// AddCounter 在 ws_counters 表中将计数器原子增加delta
+func (ss *SQLStore) AddCounter(key string, delta int64) (int64, error) {
+	var value int64
+	switch ss.dbType {
+	case "postgres":
+		err := ss.db.QueryRow(`INSERT INTO ws_counters (name, value, updated_at) VALUES ($1, $2, NOW())
+							   ON CONFLICT (name) DO UPDATE SET value = ws_counters.value + EXCLUDED.value, updated_at = EXCLUDED.updated_at
+							   RETURNING value`, key, delta).Scan(&value)
+		return value, err
+
+	case "sqlite3":
+		err := ss.db.QueryRow(`INSERT INTO ws_counters (name, value, updated_at) VALUES (?, ?, `+sqliteNow+`)
+							   ON CONFLICT (name) DO UPDATE SET value = value + excluded.value, updated_at = excluded.updated_at
+							   RETURNING value`, key, delta).Scan(&value)
+		return value, err
+
+	default:
+		// MySQL没有RETURNING，通过LAST_INSERT_ID(expr)在同一语句中取回增加后的值
+		result, err := ss.db.Exec(`INSERT INTO ws_counters (name, value, updated_at) VALUES (?, LAST_INSERT_ID(?), NOW())
+								   ON DUPLICATE KEY UPDATE value = LAST_INSERT_ID(value + ?), updated_at = NOW()`, key, delta, delta)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+}
+
+// GetCounter 获取计数器的值，不存在时为0
+func (ss *SQLStore) GetCounter(key string) (int64, error) {
+	var value int64
+	err := ss.db.QueryRow(ss.rebind(`SELECT value FROM ws_counters WHERE name = ?`), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return value, err
+}
+
+// ListCounters 获取名称以prefix开头的全部计数器
+func (ss *SQLStore) ListCounters(prefix string) (map[string]int64, error) {
+	// 转义LIKE的通配符；MySQL和SQLite的LIKE可能不区分大小写，结果再按前缀精确过滤
+	pattern := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
+	rows, err := ss.db.Query(ss.rebind(`SELECT name, value FROM ws_counters WHERE name LIKE ? ESCAPE '!'`), pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, prefix) {
+			counters[name] = value
+		}
+	}
+	return counters, rows.Err()
+}
+
+// EnqueueOffline 为离线目标缓存消息，超出maxSize时丢弃最旧的消息
+func (ss *SQLStore) EnqueueOffline(target string, message []byte, ttl time.Duration, maxSize int) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	insert := `INSERT INTO ws_offline_queue (target, content, expires_at) VALUES (?, ?, ?)`
+	// MySQL不允许在子查询中直接引用被删除的表，需要再包一层派生表
+	trim := `DELETE FROM ws_offline_queue WHERE target = ? AND id <= (
+				SELECT id FROM (SELECT id FROM ws_offline_queue WHERE target = ? ORDER BY id DESC LIMIT 1 OFFSET ?) AS t)`
+	if ss.dbType == "postgres" {
+		insert = `INSERT INTO ws_offline_queue (target, content, expires_at) VALUES ($1, $2, $3)`
+		trim = `DELETE FROM ws_offline_queue WHERE target = $1 AND id <= (
+					SELECT id FROM ws_offline_queue WHERE target = $2 ORDER BY id DESC LIMIT 1 OFFSET $3)`
+	}
+
+	if _, err := ss.db.Exec(insert, target, string(message), ss.timeArg(expiresAt)); err != nil {
+		return err
+	}
+
+	if maxSize > 0 {
+		if _, err := ss.db.Exec(trim, target, target, maxSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DequeueOffline 按入队顺序取出目标的全部未过期离线消息
+func (ss *SQLStore) DequeueOffline(target string) ([][]byte, error) {
+	query := `SELECT id, content FROM ws_offline_queue
+			  WHERE target = ? AND (expires_at IS NULL OR expires_at > NOW()) ORDER BY id FOR UPDATE`
+	remove := `DELETE FROM ws_offline_queue WHERE target = ? AND id <= ?`
+	switch ss.dbType {
+	case "postgres":
+		query = `SELECT id, content FROM ws_offline_queue
+				 WHERE target = $1 AND (expires_at IS NULL OR expires_at > NOW()) ORDER BY id FOR UPDATE`
+		remove = `DELETE FROM ws_offline_queue WHERE target = $1 AND id <= $2`
+	case "sqlite3":
+		// SQLite不支持FOR UPDATE，事务以BEGIN IMMEDIATE开始，已独占写锁
+		query = `SELECT id, content FROM ws_offline_queue
+				 WHERE target = ? AND (expires_at IS NULL OR expires_at > ` + sqliteNow + `) ORDER BY id`
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages [][]byte
+	var lastID int64
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&lastID, &content); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messages = append(messages, []byte(content))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(messages) > 0 {
+		if _, err := tx.Exec(remove, target, lastID); err != nil {
+			return nil, err
+		}
+	}
+
+	return messages, tx.Commit()
+}
+
+// SetDeliveryStatus 设置消息在某个目标上的投递状态
+func (ss *SQLStore) SetDeliveryStatus(messageID, target, status string, ttl time.Duration) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	query := `INSERT INTO ws_delivery_status (message_id, target, status, expires_at) VALUES (?, ?, ?, ?)
+			  ON DUPLICATE KEY UPDATE status = VALUES(status), updated_at = CURRENT_TIMESTAMP, expires_at = VALUES(expires_at)`
+
+	switch ss.dbType {
+	case "postgres":
+		query = `INSERT INTO ws_delivery_status (message_id, target, status, expires_at) VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (message_id, target) DO UPDATE SET status = EXCLUDED.status, updated_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at`
+	case "sqlite3":
+		query = `INSERT INTO ws_delivery_status (message_id, target, status, expires_at) VALUES (?, ?, ?, ?)
+				 ON CONFLICT (message_id, target) DO UPDATE SET status = excluded.status, updated_at = CURRENT_TIMESTAMP, expires_at = excluded.expires_at`
+	}
+
+	_, err := ss.db.Exec(query, messageID, target, status, ss.timeArg(expiresAt))
+	return err
+}
+
+// GetDeliveryStatuses 获取消息在各目标上的投递状态
+func (ss *SQLStore) GetDeliveryStatuses(messageID string) (map[string]string, error) {
+	query := ss.rebind(`SELECT target, status FROM ws_delivery_status WHERE message_id = ? AND (expires_at IS NULL OR expires_at > NOW())`)
+
+	rows, err := ss.db.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var target, status string
+		if err := rows.Scan(&target, &status); err != nil {
+			return nil, err
+		}
+		statuses[target] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, fmt.Errorf("投递状态不存在")
+	}
+	return statuses, nil
+}
+
+// SetModerationRecord 保存消息在某个目标群组上的内容审核记录
+func (ss *SQLStore) SetModerationRecord(messageID, group string, record []byte, ttl time.Duration) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	query := `INSERT INTO ws_moderation_records (message_id, group_name, record, expires_at) VALUES (?, ?, ?, ?)
+			  ON DUPLICATE KEY UPDATE record = VALUES(record), expires_at = VALUES(expires_at)`
+
+	switch ss.dbType {
+	case "postgres":
+		query = `INSERT INTO ws_moderation_records (message_id, group_name, record, expires_at) VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (message_id, group_name) DO UPDATE SET record = EXCLUDED.record, expires_at = EXCLUDED.expires_at`
+	case "sqlite3":
+		query = `INSERT INTO ws_moderation_records (message_id, group_name, record, expires_at) VALUES (?, ?, ?, ?)
+				 ON CONFLICT (message_id, group_name) DO UPDATE SET record = excluded.record, expires_at = excluded.expires_at`
+	}
+
+	_, err := ss.db.Exec(query, messageID, group, string(record), ss.timeArg(expiresAt))
+	return err
+}
+
+// GetModerationRecords 获取消息在各目标群组上的内容审核记录，没有记录时返回空map
+func (ss *SQLStore) GetModerationRecords(messageID string) (map[string][]byte, error) {
+	query := ss.rebind(`SELECT group_name, record FROM ws_moderation_records WHERE message_id = ? AND (expires_at IS NULL OR expires_at > NOW())`)
+
+	rows, err := ss.db.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string][]byte)
+	for rows.Next() {
+		var group, record string
+		if err := rows.Scan(&group, &record); err != nil {
+			return nil, err
+		}
+		records[group] = []byte(record)
+	}
+	return records, rows.Err()
 }
 
 // GetStats 获取统计信息
@@ -389,26 +1077,59 @@ func (ss *SQLStore) GetStats() (map[string]interface{}, error) {
 
 	// 获取消息数量
 	var msgCount int
-	query := `SELECT COUNT(*) FROM ws_messages WHERE expires_at IS NULL OR expires_at > NOW()`
+	query := ss.rebind(`SELECT COUNT(*) FROM ws_messages WHERE expires_at IS NULL OR expires_at > NOW()`)
 	if err := ss.db.QueryRow(query).Scan(&msgCount); err == nil {
 		stats["stored_messages"] = msgCount
 	}
 
 	// 获取状态数量
 	var statusCount int
-	query = `SELECT COUNT(*) FROM ws_message_status WHERE expires_at IS NULL OR expires_at > NOW()`
+	query = ss.rebind(`SELECT COUNT(*) FROM ws_message_status WHERE expires_at IS NULL OR expires_at > NOW()`)
 	if err := ss.db.QueryRow(query).Scan(&statusCount); err == nil {
 		stats["message_statuses"] = statusCount
 	}
 
+	// 获取离线消息数量
+	var offlineCount int
+	query = ss.rebind(`SELECT COUNT(*) FROM ws_offline_queue WHERE expires_at IS NULL OR expires_at > NOW()`)
+	if err := ss.db.QueryRow(query).Scan(&offlineCount); err == nil {
+		stats["offline_messages"] = offlineCount
+	}
+
+	// 获取历史消息数量
+	var historyCount int
+	query = ss.rebind(`SELECT COUNT(*) FROM ws_history WHERE expires_at IS NULL OR expires_at > NOW()`)
+	if err := ss.db.QueryRow(query).Scan(&historyCount); err == nil {
+		stats["history_messages"] = historyCount
+	}
+
+	// 获取计数器数量
+	var counterCount int
+	if err := ss.db.QueryRow(`SELECT COUNT(*) FROM ws_counters`).Scan(&counterCount); err == nil {
+		stats["counters"] = counterCount
+	}
+
 	ss.mutex.RLock()
-	stats["counters"] = len(ss.counters)
+	stats["purged_rows"] = ss.purge.rows
+	if !ss.purge.lastRun.IsZero() {
+		stats["last_purge"] = ss.purge.lastRun.Format("2006-01-02 15:04:05")
+	}
+	if ss.purge.lastError != "" {
+		stats["last_purge_error"] = ss.purge.lastError
+	}
 	ss.mutex.RUnlock()
 
+	if version, err := ss.schemaVersion(); err == nil {
+		stats["schema_version"] = version
+	}
+
 	return stats, nil
 }
 
-// Close 关闭数据库连接
+// Close 停止过期数据清理并关闭数据库连接
 func (ss *SQLStore) Close() error {
+	ss.stopOnce.Do(func() {
+		close(ss.stop)
+	})
 	return ss.db.Close()
 }
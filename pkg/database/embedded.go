@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/pkg/logger"
+	embedded "GRUniChat-Broadcaster/pkg/redis"
+)
+
+// EmbeddedRedisStore 使用广播器自己启动和守护的本地Redis的存储，关闭存储时停止Redis
+type EmbeddedRedisStore struct {
+	*RedisStore
+	server *embedded.EmbeddedRedis
+}
+
+// NewEmbeddedRedisStore 启动内嵌Redis并创建存储，未找到redis-server时返回embedded.ErrServerNotFound
+func NewEmbeddedRedisStore(cfg *config.EmbeddedRedisConfig, log logger.Logger) (*EmbeddedRedisStore, error) {
+	server := embedded.NewEmbeddedRedis(cfg.Port, cfg.DataDir, cfg.MaxMemory, log)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+
+	store, err := NewRedisStore(server.Addr(), "", 0, "")
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+
+	log.Infof("内嵌Redis已启动，监听地址: %s，数据目录: %s", server.Addr(), cfg.DataDir)
+	return &EmbeddedRedisStore{
+		RedisStore: store.(*RedisStore),
+		server:     server,
+	}, nil
+}
+
+// newEmbeddedOrMemoryStore 创建内嵌Redis存储，未找到redis-server时降级为内存存储
+func newEmbeddedOrMemoryStore(cfg *config.DatabaseConfig, log logger.Logger) (MessageStoreInterface, string, error) {
+	store, err := NewEmbeddedRedisStore(&cfg.EmbeddedRedis, log)
+	if errors.Is(err, embedded.ErrServerNotFound) {
+		log.Errorf("未找到redis-server，内嵌Redis降级为内存存储（重启后数据不保留）")
+		return NewMemoryStore(&cfg.Memory), "memory", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return store, "embedded_redis", nil
+}
+
+// GetStats 获取统计信息
+func (s *EmbeddedRedisStore) GetStats() (map[string]interface{}, error) {
+	stats, err := s.RedisStore.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	stats["type"] = "embedded_redis"
+	stats["addr"] = s.server.Addr()
+	stats["restarts"] = s.server.Restarts()
+	return stats, nil
+}
+
+// Close 关闭连接并停止内嵌Redis
+func (s *EmbeddedRedisStore) Close() error {
+	err := s.RedisStore.Close()
+	s.server.Stop()
+	return err
+}
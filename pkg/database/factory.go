@@ -2,22 +2,46 @@ package database
 
 import (
 	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/pkg/logger"
 	"fmt"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL驱动
 	_ "github.com/lib/pq"              // PostgreSQL驱动
+	_ "github.com/mattn/go-sqlite3"    // SQLite驱动（需要CGO）
 )
 
-// CreateMessageStore 根据配置创建消息存储实例
-func CreateMessageStore(cfg *config.DatabaseConfig) (MessageStoreInterface, error) {
+// CreateMessageStore 根据配置创建消息存储实例，写操作耗时会被记录到指标
+func CreateMessageStore(cfg *config.DatabaseConfig, log logger.Logger) (MessageStoreInterface, error) {
+	if cfg.Type == "embedded_redis" {
+		store, backend, err := newEmbeddedOrMemoryStore(cfg, log)
+		if err != nil {
+			return nil, err
+		}
+		return withMetrics(store, backend), nil
+	}
+
+	store, err := newMessageStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := cfg.Type
+	if backend == "" {
+		backend = "memory"
+	}
+	return withMetrics(store, backend), nil
+}
+
+// newMessageStore 根据数据库类型创建消息存储
+func newMessageStore(cfg *config.DatabaseConfig) (MessageStoreInterface, error) {
 	switch cfg.Type {
 	case "memory", "":
-		return NewMemoryStore(), nil
+		return NewMemoryStore(&cfg.Memory), nil
 
 	case "redis":
 		addr := fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port)
-		return NewRedisStore(addr, cfg.Redis.Password, cfg.Redis.DB)
+		return NewRedisStore(addr, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.KeyPrefix)
 
 	case "mysql":
 		if cfg.MySQL.User == "" || cfg.MySQL.Database == "" {
@@ -25,7 +49,7 @@ func CreateMessageStore(cfg *config.DatabaseConfig) (MessageStoreInterface, erro
 		}
 		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&charset=utf8mb4",
 			cfg.MySQL.User, cfg.MySQL.Password, cfg.MySQL.Host, cfg.MySQL.Port, cfg.MySQL.Database)
-		return NewSQLStore("mysql", dsn)
+		return NewSQLStore("mysql", dsn, &cfg.Purge)
 
 	case "postgresql", "postgres":
 		if cfg.PostgreSQL.User == "" || cfg.PostgreSQL.Database == "" {
@@ -34,7 +58,10 @@ func CreateMessageStore(cfg *config.DatabaseConfig) (MessageStoreInterface, erro
 		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 			cfg.PostgreSQL.Host, cfg.PostgreSQL.Port, cfg.PostgreSQL.User,
 			cfg.PostgreSQL.Password, cfg.PostgreSQL.Database, cfg.PostgreSQL.SSLMode)
-		return NewSQLStore("postgres", dsn)
+		return NewSQLStore("postgres", dsn, &cfg.Purge)
+
+	case "sqlite":
+		return NewSQLiteStore(&cfg.SQLite, &cfg.Purge)
 
 	default:
 		return nil, fmt.Errorf("不支持的数据库类型: %s", cfg.Type)
@@ -0,0 +1,418 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// HistoryEntry 一条历史消息
+type HistoryEntry struct {
+	MessageID string    `json:"messageId"`
+	Source    string    `json:"source"`
+	Type      string    `json:"type"`
+	Groups    []string  `json:"groups,omitempty"` // 发送时来源所在的群组
+	Time      time.Time `json:"time"`
+	Data      []byte    `json:"data"`
+}
+
+// HistoryQuery 历史消息查询条件，各条件为空时不限制
+type HistoryQuery struct {
+	Group   string    // 来源所在的群组
+	Source  string    // 来源服务器ID
+	Since   time.Time // 只返回该时间之后的消息
+	AfterID string    // 只返回该消息之后的消息，消息已过期时忽略
+	Limit   int       // 最多返回的条数，返回最新的若干条
+}
+
+// matches 检查历史消息是否满足除时间范围外的条件
+func (q HistoryQuery) matches(entry *HistoryEntry) bool {
+	if q.Source != "" && entry.Source != q.Source {
+		return false
+	}
+	if q.Group != "" {
+		for _, group := range entry.Groups {
+			if group == q.Group {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// historyRecord 内存中的历史消息
+type historyRecord struct {
+	entry     HistoryEntry
+	expiresAt time.Time
+}
+
+// AppendHistory 记录历史消息，超出maxEntries时删除最旧的消息
+//
+// 客户端重复使用totalId时保留第一条，与SQL存储的唯一约束一致
+func (ms *MemoryStore) AppendHistory(entry HistoryEntry, ttl time.Duration, maxEntries int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.historyIDs[entry.MessageID]; exists {
+		return nil
+	}
+	ms.historyIDs[entry.MessageID] = struct{}{}
+
+	record := historyRecord{entry: entry}
+	if ttl > 0 {
+		record.expiresAt = time.Now().Add(ttl)
+	}
+	ms.history = append(ms.history, record)
+
+	// 删除过期和超出数量的消息，消息按时间顺序追加，只需从头部删除
+	now := time.Now()
+	drop := 0
+	if maxEntries > 0 && len(ms.history) > maxEntries {
+		drop = len(ms.history) - maxEntries
+	}
+	for drop < len(ms.history) {
+		expiresAt := ms.history[drop].expiresAt
+		if expiresAt.IsZero() || now.Before(expiresAt) {
+			break
+		}
+		drop++
+	}
+	if drop > 0 {
+		for _, record := range ms.history[:drop] {
+			delete(ms.historyIDs, record.entry.MessageID)
+		}
+		ms.history = append([]historyRecord(nil), ms.history[drop:]...)
+	}
+	return nil
+}
+
+// QueryHistory 按时间顺序返回满足条件的最新若干条历史消息
+func (ms *MemoryStore) QueryHistory(query HistoryQuery) ([]HistoryEntry, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	start := 0
+	if query.AfterID != "" {
+		for i := len(ms.history) - 1; i >= 0; i-- {
+			if ms.history[i].entry.MessageID == query.AfterID {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	var entries []HistoryEntry
+	for i := len(ms.history) - 1; i >= start; i-- {
+		if query.Limit > 0 && len(entries) >= query.Limit {
+			break
+		}
+		record := &ms.history[i]
+		if !query.Since.IsZero() && !record.entry.Time.After(query.Since) {
+			break
+		}
+		if !record.expiresAt.IsZero() && now.After(record.expiresAt) {
+			continue
+		}
+		if query.matches(&record.entry) {
+			entries = append(entries, record.entry)
+		}
+	}
+	reverseHistory(entries)
+	return entries, nil
+}
+
+// reverseHistory 将按时间倒序的查询结果反转为正序
+func reverseHistory(entries []HistoryEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
+
+// Redis中的历史消息：消息内容保存在 history:msg:{id}，
+// history:all、history:group:{group}、history:source:{source} 为按时间排序的消息ID有序集合，
+// 所有键都带有存储的前缀
+const (
+	historyBatchSize  = 100
+	historyMessageKey = "history:msg:%s"
+)
+
+// historyScore 有序集合中使用的分数（微秒时间戳）
+func historyScore(t time.Time) float64 {
+	return float64(t.UnixMicro())
+}
+
+// historyAllKey 保存全部历史消息的有序集合
+func (rs *RedisStore) historyAllKey() string {
+	return rs.key("history:all")
+}
+
+// historyIndexKeys 消息所属的所有有序集合
+func (rs *RedisStore) historyIndexKeys(entry HistoryEntry) []string {
+	keys := []string{rs.historyAllKey(), rs.key("history:source:%s", entry.Source)}
+	for _, group := range entry.Groups {
+		keys = append(keys, rs.key("history:group:%s", group))
+	}
+	return keys
+}
+
+// AppendHistory 记录历史消息，每个索引最多保留maxEntries条
+func (rs *RedisStore) AppendHistory(entry HistoryEntry, ttl time.Duration, maxEntries int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	score := historyScore(entry.Time)
+	expired := "-inf"
+	if ttl > 0 {
+		expired = "(" + strconv.FormatFloat(historyScore(entry.Time.Add(-ttl)), 'f', 0, 64)
+	}
+
+	// 客户端重复使用totalId时保留第一条，与SQL存储的唯一约束一致
+	created, err := rs.client.SetNX(rs.ctx, rs.key(historyMessageKey, entry.MessageID), data, ttl).Result()
+	if err != nil || !created {
+		return err
+	}
+
+	_, err = rs.client.TxPipelined(rs.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range rs.historyIndexKeys(entry) {
+			pipe.ZAdd(rs.ctx, key, redis.Z{Score: score, Member: entry.MessageID})
+			if ttl > 0 {
+				pipe.ZRemRangeByScore(rs.ctx, key, "-inf", expired)
+				pipe.Expire(rs.ctx, key, ttl)
+			}
+			if maxEntries > 0 {
+				pipe.ZRemRangeByRank(rs.ctx, key, 0, int64(-maxEntries-1))
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// QueryHistory 按时间顺序返回满足条件的最新若干条历史消息
+func (rs *RedisStore) QueryHistory(query HistoryQuery) ([]HistoryEntry, error) {
+	// 优先使用最小的索引，其余条件在读取后过滤
+	key := rs.historyAllKey()
+	switch {
+	case query.Source != "":
+		key = rs.key("history:source:%s", query.Source)
+	case query.Group != "":
+		key = rs.key("history:group:%s", query.Group)
+	}
+
+	min := "-inf"
+	if !query.Since.IsZero() {
+		min = "(" + strconv.FormatFloat(historyScore(query.Since), 'f', 0, 64)
+	}
+	if query.AfterID != "" {
+		score, err := rs.client.ZScore(rs.ctx, rs.historyAllKey(), query.AfterID).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if err == nil && (query.Since.IsZero() || score > historyScore(query.Since)) {
+			min = "(" + strconv.FormatFloat(score, 'f', 0, 64)
+		}
+	}
+
+	var entries []HistoryEntry
+	for offset := int64(0); query.Limit <= 0 || len(entries) < query.Limit; offset += historyBatchSize {
+		ids, err := rs.client.ZRevRangeByScore(rs.ctx, key, &redis.ZRangeBy{
+			Min:    min,
+			Max:    "+inf",
+			Offset: offset,
+			Count:  historyBatchSize,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = rs.key(historyMessageKey, id)
+		}
+		values, err := rs.client.MGet(rs.ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue // 消息内容已过期
+			}
+			var entry HistoryEntry
+			if err := json.Unmarshal([]byte(data), &entry); err != nil {
+				continue
+			}
+			if query.matches(&entry) {
+				entries = append(entries, entry)
+				if query.Limit > 0 && len(entries) >= query.Limit {
+					break
+				}
+			}
+		}
+	}
+
+	reverseHistory(entries)
+	return entries, nil
+}
+
+// AppendHistory 记录历史消息，超出maxEntries时删除最旧的消息
+func (ss *SQLStore) AppendHistory(entry HistoryEntry, ttl time.Duration, maxEntries int) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		expiry := entry.Time.Add(ttl)
+		expiresAt = &expiry
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// message_id 唯一，客户端重复使用totalId时保留第一条，与内存和Redis存储的行为一致
+	var id int64
+	switch ss.dbType {
+	case "postgres":
+		err = tx.QueryRow(`INSERT INTO ws_history (message_id, source, msg_type, content, created_at, expires_at)
+						   VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (message_id) DO NOTHING RETURNING id`,
+			entry.MessageID, entry.Source, entry.Type, string(entry.Data), entry.Time, expiresAt).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+	default:
+		insert := `INSERT INTO ws_history (message_id, source, msg_type, content, created_at, expires_at)
+				   VALUES (?, ?, ?, ?, ?, ?) `
+		if ss.dbType == "sqlite3" {
+			insert += `ON CONFLICT (message_id) DO NOTHING`
+		} else {
+			insert += `ON DUPLICATE KEY UPDATE id = id`
+		}
+		var result sql.Result
+		result, err = tx.Exec(insert, entry.MessageID, entry.Source, entry.Type, string(entry.Data), ss.timeArg(&entry.Time), ss.timeArg(expiresAt))
+		if err != nil {
+			return err
+		}
+		var affected int64
+		if affected, err = result.RowsAffected(); err == nil && affected == 0 {
+			return nil
+		}
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, group := range entry.Groups {
+		if _, err := tx.Exec(ss.rebind(`INSERT INTO ws_history_groups (group_name, history_id) VALUES (?, ?)`), group, id); err != nil {
+			return err
+		}
+	}
+
+	if maxEntries > 0 {
+		// MySQL不允许在子查询中直接引用被删除的表，需要再包一层派生表
+		trim := `DELETE FROM ws_history WHERE id <= (
+					SELECT id FROM (SELECT id FROM ws_history ORDER BY id DESC LIMIT 1 OFFSET ?) AS t)`
+		if _, err := tx.Exec(ss.rebind(trim), maxEntries); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM ws_history_groups WHERE history_id < (SELECT MIN(id) FROM ws_history)`); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// QueryHistory 按时间顺序返回满足条件的最新若干条历史消息
+func (ss *SQLStore) QueryHistory(query HistoryQuery) ([]HistoryEntry, error) {
+	var sb strings.Builder
+	var args []interface{}
+
+	sb.WriteString(`SELECT h.message_id, h.source, h.msg_type, h.content, h.created_at FROM ws_history h`)
+	if query.Group != "" {
+		sb.WriteString(` JOIN ws_history_groups g ON g.history_id = h.id AND g.group_name = ?`)
+		args = append(args, query.Group)
+	}
+	sb.WriteString(` WHERE (h.expires_at IS NULL OR h.expires_at > NOW())`)
+	if query.Source != "" {
+		sb.WriteString(` AND h.source = ?`)
+		args = append(args, query.Source)
+	}
+	if !query.Since.IsZero() {
+		sb.WriteString(` AND h.created_at > ?`)
+		args = append(args, ss.timeArg(&query.Since))
+	}
+	if query.AfterID != "" {
+		sb.WriteString(` AND h.id > COALESCE((SELECT id FROM ws_history WHERE message_id = ?), 0)`)
+		args = append(args, query.AfterID)
+	}
+	sb.WriteString(` ORDER BY h.id DESC`)
+	if query.Limit > 0 {
+		sb.WriteString(` LIMIT ?`)
+		args = append(args, query.Limit)
+	}
+
+	rows, err := ss.db.Query(ss.rebind(sb.String()), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var content string
+		if err := rows.Scan(&entry.MessageID, &entry.Source, &entry.Type, &content, &entry.Time); err != nil {
+			return nil, err
+		}
+		entry.Data = []byte(content)
+		if query.Group != "" {
+			entry.Groups = []string{query.Group}
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reverseHistory(entries)
+	return entries, nil
+}
+
+// rebind 将按MySQL写法（? 占位符、NOW()）编写的查询转换为当前数据库的写法：
+// PostgreSQL使用 $n 占位符，SQLite没有 NOW() 函数
+func (ss *SQLStore) rebind(query string) string {
+	if ss.dbType == "sqlite3" {
+		return strings.ReplaceAll(query, "NOW()", sqliteNow)
+	}
+	if ss.dbType != "postgres" {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
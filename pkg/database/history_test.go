@@ -0,0 +1,64 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+)
+
+// testDuplicateHistory 重复的totalId只保留第一条，后续消息照常记录
+func testDuplicateHistory(t *testing.T, store MessageStoreInterface) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i, entry := range []HistoryEntry{
+		{MessageID: "dup", Source: "survival", Type: "chat", Groups: []string{"g"}, Time: now, Data: []byte("first")},
+		{MessageID: "dup", Source: "creative", Type: "chat", Groups: []string{"g"}, Time: now.Add(time.Millisecond), Data: []byte("second")},
+		{MessageID: "next", Source: "survival", Type: "chat", Groups: []string{"g"}, Time: now.Add(2 * time.Millisecond), Data: []byte("next")},
+	} {
+		if err := store.AppendHistory(entry, time.Hour, 100); err != nil {
+			t.Fatalf("第%d条AppendHistory失败: %v", i+1, err)
+		}
+	}
+
+	entries, err := store.QueryHistory(HistoryQuery{Group: "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || string(entries[0].Data) != "first" || entries[0].Source != "survival" || entries[1].MessageID != "next" {
+		t.Fatalf("历史消息 = %+v, 期望 first 和 next", entries)
+	}
+	if entries, err := store.QueryHistory(HistoryQuery{AfterID: "dup"}); err != nil || len(entries) != 1 || entries[0].MessageID != "next" {
+		t.Fatalf("AfterID查询 = %+v, %v", entries, err)
+	}
+}
+
+func TestMemoryHistoryDuplicateID(t *testing.T) {
+	store := NewMemoryStore(&config.MemoryConfig{MaxEntries: 100, MaxBytes: 1 << 20, CleanupInterval: 60})
+	defer store.Close()
+	testDuplicateHistory(t, store)
+}
+
+func TestMemoryHistoryTrimForgetsID(t *testing.T) {
+	store := NewMemoryStore(&config.MemoryConfig{MaxEntries: 100, MaxBytes: 1 << 20, CleanupInterval: 60})
+	defer store.Close()
+
+	// 被裁剪掉的消息ID可以再次记录
+	now := time.Now()
+	for i, id := range []string{"a", "b", "a"} {
+		if err := store.AppendHistory(HistoryEntry{MessageID: id, Source: "s", Type: "chat", Time: now.Add(time.Duration(i) * time.Millisecond)}, time.Hour, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.QueryHistory(HistoryQuery{})
+	if err != nil || len(entries) != 1 || entries[0].MessageID != "a" {
+		t.Fatalf("历史消息 = %+v, %v, 期望只剩最新的a", entries, err)
+	}
+}
+
+func TestSQLiteHistoryDuplicateID(t *testing.T) {
+	ss := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	defer ss.Close()
+	testDuplicateHistory(t, ss)
+}
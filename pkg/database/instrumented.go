@@ -0,0 +1,80 @@
+package database
+
+import (
+	"time"
+
+	"GRUniChat-Broadcaster/pkg/metrics"
+)
+
+// instrumentedStore 为消息存储的写操作记录耗时指标
+type instrumentedStore struct {
+	MessageStoreInterface
+	backend string
+}
+
+// withMetrics 包装消息存储，按后端类型记录写入耗时
+func withMetrics(store MessageStoreInterface, backend string) MessageStoreInterface {
+	return &instrumentedStore{
+		MessageStoreInterface: store,
+		backend:               backend,
+	}
+}
+
+// observe 记录一次写操作的耗时
+func (s *instrumentedStore) observe(operation string, start time.Time) {
+	metrics.StoreWriteDuration.WithLabelValues(s.backend, operation).ObserveSince(start)
+}
+
+// StoreMessage 存储消息
+func (s *instrumentedStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
+	defer s.observe("store_message", time.Now())
+	return s.MessageStoreInterface.StoreMessage(messageID, message, ttl)
+}
+
+// StoreMessageWithStatus 存储消息并设置状态
+func (s *instrumentedStore) StoreMessageWithStatus(messageID string, message []byte, status string, ttl time.Duration) error {
+	defer s.observe("store_message_with_status", time.Now())
+	return s.MessageStoreInterface.StoreMessageWithStatus(messageID, message, status, ttl)
+}
+
+// SetMessageStatus 设置消息状态
+func (s *instrumentedStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
+	defer s.observe("set_message_status", time.Now())
+	return s.MessageStoreInterface.SetMessageStatus(messageID, status, ttl)
+}
+
+// IncrementCounter 递增计数器
+func (s *instrumentedStore) IncrementCounter(key string) (int64, error) {
+	defer s.observe("increment_counter", time.Now())
+	return s.MessageStoreInterface.IncrementCounter(key)
+}
+
+// AddCounter 将计数器增加delta
+func (s *instrumentedStore) AddCounter(key string, delta int64) (int64, error) {
+	defer s.observe("add_counter", time.Now())
+	return s.MessageStoreInterface.AddCounter(key, delta)
+}
+
+// EnqueueOffline 缓存离线消息
+func (s *instrumentedStore) EnqueueOffline(target string, message []byte, ttl time.Duration, maxSize int) error {
+	defer s.observe("enqueue_offline", time.Now())
+	return s.MessageStoreInterface.EnqueueOffline(target, message, ttl, maxSize)
+}
+
+// SetDeliveryStatus 设置目标投递状态
+func (s *instrumentedStore) SetDeliveryStatus(messageID, target, status string, ttl time.Duration) error {
+	defer s.observe("set_delivery_status", time.Now())
+	return s.MessageStoreInterface.SetDeliveryStatus(messageID, target, status, ttl)
+}
+
+// SetModerationRecord 保存内容审核记录
+func (s *instrumentedStore) SetModerationRecord(messageID, group string, record []byte, ttl time.Duration) error {
+	defer s.observe("set_moderation_record", time.Now())
+	return s.MessageStoreInterface.SetModerationRecord(messageID, group, record, ttl)
+}
+
+// AppendHistory 记录历史消息
+func (s *instrumentedStore) AppendHistory(entry HistoryEntry, ttl time.Duration, maxEntries int) error {
+	defer s.observe("append_history", time.Now())
+	return s.MessageStoreInterface.AppendHistory(entry, ttl, maxEntries)
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"container/list"
+	"time"
+)
+
+// lruEntry LRU缓存中的一项
+type lruEntry struct {
+	kind      string // 数据类别，用于统计
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// size 估算占用的字节数
+func (e *lruEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// expired 检查是否已过期
+func (e *lruEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// lruCache 带过期时间和容量上限的LRU缓存，调用方负责加锁
+type lruCache struct {
+	items      map[string]*list.Element
+	order      *list.List // 最近使用的在前
+	counts     map[string]int
+	maxEntries int   // 最多保存的项数，<=0表示不限制
+	maxBytes   int64 // 最多占用的字节数，<=0表示不限制
+	bytes      int64
+
+	evictions   int64 // 因超出容量被淘汰的项数
+	expirations int64 // 因过期被清理的项数
+}
+
+// newLRUCache 创建LRU缓存
+func newLRUCache(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		counts:     make(map[string]int),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+// get 获取未过期的值并标记为最近使用，已过期的项会被删除
+func (c *lruCache) get(kind, key string, now time.Time) ([]byte, bool) {
+	elem, exists := c.items[kind+":"+key]
+	if !exists {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if entry.expired(now) {
+		c.removeElement(elem)
+		c.expirations++
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set 写入值，ttl<=0表示不过期，超出容量时淘汰最久未使用的项
+func (c *lruCache) set(kind, key string, value []byte, ttl time.Duration, now time.Time) {
+	entry := &lruEntry{kind: kind, key: kind + ":" + key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+
+	if elem, exists := c.items[entry.key]; exists {
+		c.bytes -= elem.Value.(*lruEntry).size()
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+	} else {
+		c.items[entry.key] = c.order.PushFront(entry)
+		c.counts[kind]++
+	}
+	c.bytes += entry.size()
+
+	// 至少保留刚写入的项
+	for c.order.Len() > 1 && c.overCapacity() {
+		c.removeElement(c.order.Back())
+		c.evictions++
+	}
+}
+
+// delete 删除一项
+func (c *lruCache) delete(kind, key string) {
+	if elem, exists := c.items[kind+":"+key]; exists {
+		c.removeElement(elem)
+	}
+}
+
+// removeExpired 删除所有已过期的项，返回删除的数量
+func (c *lruCache) removeExpired(now time.Time) int {
+	removed := 0
+	for elem := c.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(*lruEntry).expired(now) {
+			c.removeElement(elem)
+			removed++
+		}
+		elem = prev
+	}
+	c.expirations += int64(removed)
+	return removed
+}
+
+// count 获取某一类别的项数
+func (c *lruCache) count(kind string) int {
+	return c.counts[kind]
+}
+
+// overCapacity 检查是否超出容量上限
+func (c *lruCache) overCapacity() bool {
+	return (c.maxEntries > 0 && c.order.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+// removeElement 从缓存中移除元素
+func (c *lruCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.order.Remove(elem)
+	delete(c.items, entry.key)
+	c.counts[entry.kind]--
+	c.bytes -= entry.size()
+}
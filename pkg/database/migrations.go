@@ -0,0 +1,344 @@
+package database
+
+import (
+	"fmt"
+)
+
+// migration 一次表结构变更，statements按数据库类型区分
+//
+// 版本1、2的表在引入版本表之前就已存在，因此仍使用 IF NOT EXISTS，
+// 以便已有的数据库直接记为已迁移；之后的版本只会执行一次，不需要重复判断。
+type migration struct {
+	version     int
+	description string
+	statements  map[string][]string
+}
+
+// migrations 按版本顺序排列的表结构变更，只能追加，不能修改已发布的版本
+var migrations = []migration{
+	{
+		version:     1,
+		description: "消息、状态、离线队列和投递状态表",
+		statements: map[string][]string{
+			"mysql": {
+				`CREATE TABLE IF NOT EXISTS ws_messages (
+					id VARCHAR(255) PRIMARY KEY,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_message_status (
+					message_id VARCHAR(255) PRIMARY KEY,
+					status VARCHAR(50) NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_offline_queue (
+					id BIGINT AUTO_INCREMENT PRIMARY KEY,
+					target VARCHAR(255) NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NULL,
+					INDEX idx_offline_target (target, id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_delivery_status (
+					message_id VARCHAR(255) NOT NULL,
+					target VARCHAR(255) NOT NULL,
+					status VARCHAR(50) NOT NULL,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NULL,
+					PRIMARY KEY (message_id, target)
+				)`,
+			},
+			"postgres": {
+				`CREATE TABLE IF NOT EXISTS ws_messages (
+					id VARCHAR(255) PRIMARY KEY,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_message_status (
+					message_id VARCHAR(255) PRIMARY KEY,
+					status VARCHAR(50) NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_offline_queue (
+					id BIGSERIAL PRIMARY KEY,
+					target VARCHAR(255) NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_delivery_status (
+					message_id VARCHAR(255) NOT NULL,
+					target VARCHAR(255) NOT NULL,
+					status VARCHAR(50) NOT NULL,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP,
+					PRIMARY KEY (message_id, target)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_offline_target ON ws_offline_queue (target, id)`,
+			},
+			"sqlite3": {
+				`CREATE TABLE IF NOT EXISTS ws_messages (
+					id TEXT PRIMARY KEY,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_message_status (
+					message_id TEXT PRIMARY KEY,
+					status TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_offline_queue (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					target TEXT NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_delivery_status (
+					message_id TEXT NOT NULL,
+					target TEXT NOT NULL,
+					status TEXT NOT NULL,
+					updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP,
+					PRIMARY KEY (message_id, target)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_offline_target ON ws_offline_queue (target, id)`,
+			},
+		},
+	},
+	{
+		version:     2,
+		description: "历史消息表",
+		statements: map[string][]string{
+			"mysql": {
+				`CREATE TABLE IF NOT EXISTS ws_history (
+					id BIGINT AUTO_INCREMENT PRIMARY KEY,
+					message_id VARCHAR(255) NOT NULL,
+					source VARCHAR(255) NOT NULL,
+					msg_type VARCHAR(50) NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP(6) NOT NULL,
+					expires_at TIMESTAMP NULL,
+					UNIQUE KEY uk_history_message (message_id),
+					INDEX idx_history_source (source, id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_history_groups (
+					group_name VARCHAR(255) NOT NULL,
+					history_id BIGINT NOT NULL,
+					PRIMARY KEY (group_name, history_id)
+				)`,
+			},
+			"postgres": {
+				`CREATE TABLE IF NOT EXISTS ws_history (
+					id BIGSERIAL PRIMARY KEY,
+					message_id VARCHAR(255) NOT NULL UNIQUE,
+					source VARCHAR(255) NOT NULL,
+					msg_type VARCHAR(50) NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_history_groups (
+					group_name VARCHAR(255) NOT NULL,
+					history_id BIGINT NOT NULL,
+					PRIMARY KEY (group_name, history_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_history_source ON ws_history (source, id)`,
+			},
+			"sqlite3": {
+				`CREATE TABLE IF NOT EXISTS ws_history (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					message_id TEXT NOT NULL UNIQUE,
+					source TEXT NOT NULL,
+					msg_type TEXT NOT NULL,
+					content TEXT NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					expires_at TIMESTAMP
+				)`,
+				`CREATE TABLE IF NOT EXISTS ws_history_groups (
+					group_name TEXT NOT NULL,
+					history_id INTEGER NOT NULL,
+					PRIMARY KEY (group_name, history_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_history_source ON ws_history (source, id)`,
+			},
+		},
+	},
+	{
+		version:     3,
+		description: "过期清理和历史查询索引",
+		statements: map[string][]string{
+			"mysql": {
+				`CREATE INDEX idx_messages_expires ON ws_messages (expires_at)`,
+				`CREATE INDEX idx_status_expires ON ws_message_status (expires_at)`,
+				`CREATE INDEX idx_offline_expires ON ws_offline_queue (expires_at)`,
+				`CREATE INDEX idx_delivery_expires ON ws_delivery_status (expires_at)`,
+				`CREATE INDEX idx_history_expires ON ws_history (expires_at)`,
+				`CREATE INDEX idx_history_created ON ws_history (created_at)`,
+				`CREATE INDEX idx_history_groups_history ON ws_history_groups (history_id)`,
+			},
+			"postgres": {
+				`CREATE INDEX idx_messages_expires ON ws_messages (expires_at)`,
+				`CREATE INDEX idx_status_expires ON ws_message_status (expires_at)`,
+				`CREATE INDEX idx_offline_expires ON ws_offline_queue (expires_at)`,
+				`CREATE INDEX idx_delivery_expires ON ws_delivery_status (expires_at)`,
+				`CREATE INDEX idx_history_expires ON ws_history (expires_at)`,
+				`CREATE INDEX idx_history_created ON ws_history (created_at)`,
+				`CREATE INDEX idx_history_groups_history ON ws_history_groups (history_id)`,
+			},
+			"sqlite3": {
+				`CREATE INDEX idx_messages_expires ON ws_messages (expires_at)`,
+				`CREATE INDEX idx_status_expires ON ws_message_status (expires_at)`,
+				`CREATE INDEX idx_offline_expires ON ws_offline_queue (expires_at)`,
+				`CREATE INDEX idx_delivery_expires ON ws_delivery_status (expires_at)`,
+				`CREATE INDEX idx_history_expires ON ws_history (expires_at)`,
+				`CREATE INDEX idx_history_created ON ws_history (created_at)`,
+				`CREATE INDEX idx_history_groups_history ON ws_history_groups (history_id)`,
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "SQLite计数器表",
+		statements: map[string][]string{
+			// MySQL/PostgreSQL的计数器表在版本5中创建
+			"mysql":    {},
+			"postgres": {},
+			"sqlite3": {
+				`CREATE TABLE ws_counters (
+					name TEXT PRIMARY KEY,
+					value INTEGER NOT NULL DEFAULT 0,
+					updated_at TIMESTAMP
+				)`,
+			},
+		},
+	},
+	{
+		version:     5,
+		description: "计数器表",
+		statements: map[string][]string{
+			"mysql": {
+				// 计数器名称包含服务器ID，需要区分大小写
+				`CREATE TABLE ws_counters (
+					name VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY,
+					value BIGINT NOT NULL DEFAULT 0,
+					updated_at TIMESTAMP NULL
+				)`,
+			},
+			"postgres": {
+				`CREATE TABLE ws_counters (
+					name VARCHAR(255) PRIMARY KEY,
+					value BIGINT NOT NULL DEFAULT 0,
+					updated_at TIMESTAMP
+				)`,
+			},
+			// SQLite的计数器表已在版本4中创建
+			"sqlite3": {},
+		},
+	},
+	{
+		version:     6,
+		description: "内容审核记录表",
+		statements: map[string][]string{
+			"mysql": {
+				`CREATE TABLE ws_moderation_records (
+					message_id VARCHAR(255) NOT NULL,
+					group_name VARCHAR(255) NOT NULL,
+					record TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP NULL,
+					PRIMARY KEY (message_id, group_name),
+					INDEX idx_moderation_expires (expires_at)
+				)`,
+			},
+			"postgres": {
+				`CREATE TABLE ws_moderation_records (
+					message_id VARCHAR(255) NOT NULL,
+					group_name VARCHAR(255) NOT NULL,
+					record TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP,
+					PRIMARY KEY (message_id, group_name)
+				)`,
+				`CREATE INDEX idx_moderation_expires ON ws_moderation_records (expires_at)`,
+			},
+			"sqlite3": {
+				`CREATE TABLE ws_moderation_records (
+					message_id TEXT NOT NULL,
+					group_name TEXT NOT NULL,
+					record TEXT NOT NULL,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP,
+					PRIMARY KEY (message_id, group_name)
+				)`,
+				`CREATE INDEX idx_moderation_expires ON ws_moderation_records (expires_at)`,
+			},
+		},
+	},
+}
+
+// migrate 按版本执行尚未应用的表结构变更，并记录到 ws_schema_migrations
+func (ss *SQLStore) migrate() error {
+	createVersionTable := `
+		CREATE TABLE IF NOT EXISTS ws_schema_migrations (
+			version INT PRIMARY KEY,
+			description VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`
+	if _, err := ss.db.Exec(createVersionTable); err != nil {
+		return fmt.Errorf("创建版本表失败: %v", err)
+	}
+
+	current, err := ss.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		statements, exists := m.statements[ss.dbType]
+		if !exists {
+			return fmt.Errorf("迁移 %d 不支持数据库类型 %s", m.version, ss.dbType)
+		}
+		if err := ss.applyMigration(m, statements); err != nil {
+			return fmt.Errorf("执行迁移 %d（%s）失败: %v", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion 获取已应用的最高版本，未迁移过时为0
+func (ss *SQLStore) schemaVersion() (int, error) {
+	var version int
+	if err := ss.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM ws_schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("查询表结构版本失败: %v", err)
+	}
+	return version, nil
+}
+
+// applyMigration 在事务中执行一次迁移并记录版本（MySQL的DDL会隐式提交，失败时可能需要手动处理）
+func (ss *SQLStore) applyMigration(m migration, statements []string) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ss.rebind(`INSERT INTO ws_schema_migrations (version, description) VALUES (?, ?)`), m.version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"time"
+
+	"GRUniChat-Broadcaster/pkg/metrics"
+)
+
+// purgeTables 带 expires_at 列、需要定期清理的表
+var purgeTables = []string{
+	"ws_messages",
+	"ws_message_status",
+	"ws_offline_queue",
+	"ws_delivery_status",
+	"ws_history",
+	"ws_moderation_records",
+}
+
+// purgeStats 过期数据清理统计
+type purgeStats struct {
+	rows      int64
+	lastRun   time.Time
+	lastError string
+}
+
+// purgeLoop 定期清理过期数据，直到存储关闭
+func (ss *SQLStore) purgeLoop(interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ss.purgeExpired(batchSize)
+		case <-ss.stop:
+			return
+		}
+	}
+}
+
+// purgeExpired 按批删除各表中已过期的行，并清理历史消息群组表中失去对应消息的行
+func (ss *SQLStore) purgeExpired(batchSize int) {
+	var total int64
+	var lastErr error
+
+	for _, table := range purgeTables {
+		removed, err := ss.purgeTable(table, batchSize)
+		if removed > 0 {
+			metrics.StorePurgedRows.WithLabelValues(table).Add(float64(removed))
+			total += removed
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	orphans := `DELETE FROM ws_history_groups WHERE NOT EXISTS (SELECT 1 FROM ws_history h WHERE h.id = ws_history_groups.history_id)`
+	if result, err := ss.db.Exec(orphans); err != nil {
+		lastErr = err
+	} else if removed, _ := result.RowsAffected(); removed > 0 {
+		metrics.StorePurgedRows.WithLabelValues("ws_history_groups").Add(float64(removed))
+		total += removed
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.purge.rows += total
+	ss.purge.lastRun = time.Now()
+	ss.purge.lastError = ""
+	if lastErr != nil {
+		ss.purge.lastError = lastErr.Error()
+	}
+}
+
+// purgeTable 按批删除表中已过期的行，返回删除的总行数
+func (ss *SQLStore) purgeTable(table string, batchSize int) (int64, error) {
+	query := `DELETE FROM ` + table + ` WHERE expires_at IS NOT NULL AND expires_at <= NOW() LIMIT ?`
+	switch ss.dbType {
+	case "postgres":
+		// PostgreSQL的DELETE不支持LIMIT，按ctid分批
+		query = `DELETE FROM ` + table + ` WHERE ctid IN (
+					SELECT ctid FROM ` + table + ` WHERE expires_at IS NOT NULL AND expires_at <= NOW() LIMIT $1)`
+	case "sqlite3":
+		// SQLite默认编译选项下DELETE不支持LIMIT，按rowid分批
+		query = `DELETE FROM ` + table + ` WHERE rowid IN (
+					SELECT rowid FROM ` + table + ` WHERE expires_at IS NOT NULL AND expires_at <= ` + sqliteNow + ` LIMIT ?)`
+	}
+
+	var total int64
+	for {
+		select {
+		case <-ss.stop:
+			return total, nil
+		default:
+		}
+
+		result, err := ss.db.Exec(query, batchSize)
+		if err != nil {
+			return total, err
+		}
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += removed
+		if removed < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+)
+
+// SQLite没有时间类型，时间统一以UTC毫秒精度的定长字符串保存，按字符串比较即按时间比较；
+// 格式与 strftime('%Y-%m-%d %H:%M:%f', 'now') 的结果一致，rebind 会用它替换查询中的 NOW()
+const (
+	sqliteTimeFormat = "2006-01-02 15:04:05.000"
+	sqliteNow        = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+)
+
+// NewSQLiteStore 创建SQLite存储，数据库文件使用WAL模式，允许读写并发
+//
+// 写事务以 BEGIN IMMEDIATE 开始，并在数据库被占用时最多等待5秒，避免多个连接同时写入时直接失败
+func NewSQLiteStore(cfg *config.SQLiteConfig, purge *config.PurgeConfig) (MessageStoreInterface, error) {
+	if dir := filepath.Dir(cfg.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建SQLite数据目录失败: %v", err)
+		}
+	}
+
+	dsn := cfg.Path + "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_txlock=immediate"
+	return NewSQLStore("sqlite3", dsn, purge)
+}
+
+// timeArg 将时间参数转换为当前数据库保存的格式，nil表示不过期
+func (ss *SQLStore) timeArg(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	if ss.dbType == "sqlite3" {
+		return t.UTC().Format(sqliteTimeFormat)
+	}
+	return *t
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+)
+
+// openTestSQLite 在临时目录中创建SQLite存储，未启用CGO编译时跳过测试
+func openTestSQLite(t *testing.T, path string) *SQLStore {
+	t.Helper()
+	store, err := NewSQLiteStore(&config.SQLiteConfig{Path: path}, &config.PurgeConfig{Interval: 3600, BatchSize: 1000})
+	if err != nil {
+		if strings.Contains(err.Error(), "CGO_ENABLED=0") {
+			t.Skip("SQLite驱动需要CGO")
+		}
+		t.Fatalf("创建SQLite存储失败: %v", err)
+	}
+	return store.(*SQLStore)
+}
+
+// appliedVersions 查询已记录的迁移版本
+func appliedVersions(t *testing.T, ss *SQLStore) []int {
+	t.Helper()
+	rows, err := ss.db.Query(`SELECT version FROM ws_schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatalf("查询迁移版本失败: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+func TestSQLiteMigrationsApplyOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "test.db")
+	latest := migrations[len(migrations)-1].version
+
+	ss := openTestSQLite(t, path)
+	versions := appliedVersions(t, ss)
+	if len(versions) != len(migrations) || versions[len(versions)-1] != latest {
+		t.Fatalf("已应用版本 = %v, 期望 1..%d", versions, latest)
+	}
+	if _, err := ss.IncrementCounter("messages:type:chat"); err != nil {
+		t.Fatalf("迁移后计数器表不可用: %v", err)
+	}
+	ss.Close()
+
+	// 再次打开时不重复执行迁移，已有数据保留
+	ss = openTestSQLite(t, path)
+	defer ss.Close()
+	if versions := appliedVersions(t, ss); len(versions) != len(migrations) {
+		t.Fatalf("重新打开后的版本记录 = %v", versions)
+	}
+	if value, err := ss.GetCounter("messages:type:chat"); err != nil || value != 1 {
+		t.Fatalf("重新打开后计数器 = %d, %v, 期望 1", value, err)
+	}
+	if value, err := ss.AddCounter("messages:type:chat", 3); err != nil || value != 4 {
+		t.Fatalf("AddCounter = %d, %v, 期望 4", value, err)
+	}
+}
+
+func TestSQLiteMigrationsResumeFromVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// 模拟只迁移到版本3的旧数据库
+	ss := openTestSQLite(t, path)
+	for _, statement := range []string{
+		`DROP TABLE ws_counters`,
+		`DROP TABLE ws_moderation_records`,
+		`DELETE FROM ws_schema_migrations WHERE version > 3`,
+	} {
+		if _, err := ss.db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	ss.Close()
+
+	ss = openTestSQLite(t, path)
+	defer ss.Close()
+	if version, err := ss.schemaVersion(); err != nil || version != migrations[len(migrations)-1].version {
+		t.Fatalf("schemaVersion = %d, %v", version, err)
+	}
+	if _, err := ss.IncrementCounter("resumed"); err != nil {
+		t.Errorf("版本4之后的迁移未执行: %v", err)
+	}
+	if err := ss.SetModerationRecord("m1", "g", []byte(`{}`), time.Minute); err != nil {
+		t.Errorf("版本6的迁移未执行: %v", err)
+	}
+}
+
+func TestSQLitePurgeBatches(t *testing.T) {
+	ss := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	defer ss.Close()
+
+	past := time.Now().Add(-time.Minute)
+	for i := 0; i < 25; i++ {
+		if _, err := ss.db.Exec(`INSERT INTO ws_messages (id, content, expires_at) VALUES (?, ?, ?)`,
+			fmt.Sprintf("expired-%d", i), "x", ss.timeArg(&past)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ss.StoreMessage("live", []byte("x"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreMessage("forever", []byte("x"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := ss.purgeTable("ws_messages", 10)
+	if err != nil || removed != 25 {
+		t.Fatalf("purgeTable = %d, %v, 期望分批删除25行", removed, err)
+	}
+	for _, id := range []string{"live", "forever"} {
+		if _, err := ss.GetMessage(id); err != nil {
+			t.Errorf("未过期的消息 %s 被删除: %v", id, err)
+		}
+	}
+	if removed, err := ss.purgeTable("ws_messages", 10); err != nil || removed != 0 {
+		t.Errorf("再次清理 = %d, %v, 期望 0", removed, err)
+	}
+}
+
+func TestSQLitePurgeExpiredStats(t *testing.T) {
+	ss := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	defer ss.Close()
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := ss.db.Exec(`INSERT INTO ws_message_status (message_id, status, expires_at) VALUES (?, ?, ?)`,
+		"m1", "success", ss.timeArg(&past)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.db.Exec(`INSERT INTO ws_moderation_records (message_id, group_name, record, expires_at) VALUES (?, ?, ?, ?)`,
+		"m1", "g", "{}", ss.timeArg(&past)); err != nil {
+		t.Fatal(err)
+	}
+	// 失去对应历史消息的群组索引也会被清理
+	if _, err := ss.db.Exec(`INSERT INTO ws_history_groups (group_name, history_id) VALUES (?, ?)`, "g", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	ss.purgeExpired(1)
+
+	stats, err := ss.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["purged_rows"] != int64(3) {
+		t.Errorf("purged_rows = %v, 期望 3", stats["purged_rows"])
+	}
+	if _, exists := stats["last_purge"]; !exists {
+		t.Error("缺少last_purge")
+	}
+	if _, exists := stats["last_purge_error"]; exists {
+		t.Errorf("清理出错: %v", stats["last_purge_error"])
+	}
+}
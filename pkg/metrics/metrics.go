@@ -0,0 +1,54 @@
+package metrics
+
+import "time"
+
+// 消息丢弃原因
+const (
+	DropMiddleware = "middleware"  // 被中间件拒绝或过滤
+	DropNoRoute    = "no_route"    // 没有匹配的路由目标
+	DropBlacklist  = "blacklist"   // 被群组黑名单过滤
+	DropOffline    = "offline"     // 目标离线且未启用离线队列
+	DropSendFailed = "send_failed" // 写入目标发送通道失败
+	DropModeration = "moderation"  // 被内容审核拦截
+	DropLoop       = "loop"        // 从其他集群节点收到的消息再次进入广播
+)
+
+// 热重载结果
+const (
+	ReloadSuccess       = "success"
+	ReloadLoadError     = "load_error"
+	ReloadInvalid       = "validation_error"
+	ReloadCallbackError = "callback_error"
+	ReloadCancelled     = "cancelled"
+)
+
+// 广播器指标
+var (
+	MessagesReceived = NewCounterVec("grunichat_messages_received_total",
+		"客户端发来的待广播消息数", "source", "type")
+	MessagesBroadcast = NewCounterVec("grunichat_messages_broadcast_total",
+		"成功写入目标发送通道的消息数", "source", "type", "target")
+	MessagesQueued = NewCounterVec("grunichat_messages_queued_total",
+		"目标离线时进入离线队列的消息数", "source", "type", "target")
+	MessagesDropped = NewCounterVec("grunichat_messages_dropped_total",
+		"未能送达的消息数，target为空表示路由前即被丢弃", "source", "type", "target", "reason")
+	BlacklistHits = NewCounterVec("grunichat_blacklist_hits_total",
+		"群组黑名单规则命中次数", "rule")
+	SendChannelFull = NewCounterVec("grunichat_send_channel_full_total",
+		"连接发送通道已满导致丢弃的消息数", "target")
+	StoreWriteDuration = NewHistogramVec("grunichat_store_write_duration_seconds",
+		"消息存储写操作耗时", DefaultBuckets, "backend", "operation")
+	ModerationActions = NewCounterVec("grunichat_moderation_actions_total",
+		"内容审核命中后执行的动作次数", "group", "action")
+	StorePurgedRows = NewCounterVec("grunichat_store_purged_rows_total",
+		"SQL存储清理的过期行数", "table")
+	ClusterMessages = NewCounterVec("grunichat_cluster_messages_total",
+		"集群节点间收发的消息数", "direction", "kind")
+	HotReloads = NewCounterVec("grunichat_hot_reloads_total",
+		"配置热重载结果", "result")
+)
+
+// ObserveSince 记录从start到现在的耗时（秒）
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
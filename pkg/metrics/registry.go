@@ -0,0 +1,349 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector 可以输出文本格式指标的采集器
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry 指标注册表，按注册顺序输出Prometheus文本格式
+type Registry struct {
+	collectors []collector
+	mu         sync.RWMutex
+}
+
+// NewRegistry 创建新的指标注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry 默认注册表，包内定义的指标都注册在这里
+var DefaultRegistry = NewRegistry()
+
+// register 注册采集器，同名采集器会被替换
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.collectors {
+		if existing.name() == c.name() {
+			r.collectors[i] = c
+			return
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText 以Prometheus文本格式输出所有指标
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.RLock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler 返回输出指标的HTTP处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Handler 返回输出默认注册表指标的HTTP处理器
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// labelSet 一组标签值
+type labelSet struct {
+	names  []string
+	values []string
+}
+
+// seriesKey 将标签值拼接为序列的键
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// format 输出 {a="x",b="y"} 形式的标签，extra追加在最后（用于直方图的le）
+func (l labelSet) format(extra ...string) string {
+	pairs := make([]string, 0, len(l.names)+len(extra)/2)
+	for i, name := range l.names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(l.values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel 转义标签值中的反斜杠、双引号和换行
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// formatFloat 按Prometheus文本格式输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeHeader 输出HELP和TYPE行
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// checkLabels 检查标签值数量与定义一致
+func checkLabels(metric string, names, values []string) {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际为 %d", metric, len(names), len(values)))
+	}
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	metric string
+	help   string
+	labels []string
+	series map[string]*Counter
+	mu     sync.RWMutex
+}
+
+// Counter 单个计数器序列
+type Counter struct {
+	labels labelSet
+	value  float64
+	mu     sync.Mutex
+}
+
+// NewCounterVec 创建计数器并注册到默认注册表
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metric: name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*Counter),
+	}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// WithLabelValues 获取指定标签值的计数器，不存在时创建
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	checkLabels(c.metric, c.labels, values)
+	key := seriesKey(values)
+
+	c.mu.RLock()
+	counter, exists := c.series[key]
+	c.mu.RUnlock()
+	if exists {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, exists = c.series[key]; !exists {
+		counter = &Counter{labels: labelSet{names: c.labels, values: append([]string{}, values...)}}
+		c.series[key] = counter
+	}
+	return counter
+}
+
+// Inc 计数加1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add 计数增加v，v不能为负数
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) name() string { return c.metric }
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.metric, c.help, "counter")
+	for _, counter := range c.sorted() {
+		counter.mu.Lock()
+		value := counter.value
+		counter.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", c.metric, counter.labels.format(), formatFloat(value))
+	}
+}
+
+// sorted 按标签值排序返回所有序列，保证输出稳定
+func (c *CounterVec) sorted() []*Counter {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]*Counter, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, c.series[key])
+	}
+	return series
+}
+
+// DefaultBuckets 默认直方图桶（秒），覆盖内存存储到远程数据库的写入耗时
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	metric  string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*Histogram
+	mu      sync.RWMutex
+}
+
+// Histogram 单个直方图序列
+type Histogram struct {
+	labels  labelSet
+	buckets []float64
+	counts  []uint64 // 每个桶的非累积计数
+	sum     float64
+	count   uint64
+	mu      sync.Mutex
+}
+
+// NewHistogramVec 创建直方图并注册到默认注册表，buckets需升序
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metric:  name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*Histogram),
+	}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// WithLabelValues 获取指定标签值的直方图，不存在时创建
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	checkLabels(h.metric, h.labels, values)
+	key := seriesKey(values)
+
+	h.mu.RLock()
+	histogram, exists := h.series[key]
+	h.mu.RUnlock()
+	if exists {
+		return histogram
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if histogram, exists = h.series[key]; !exists {
+		histogram = &Histogram{
+			labels:  labelSet{names: h.labels, values: append([]string{}, values...)},
+			buckets: h.buckets,
+			counts:  make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = histogram
+	}
+	return histogram
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *HistogramVec) name() string { return h.metric }
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.metric, h.help, "histogram")
+
+	h.mu.RLock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*Histogram, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, h.series[key])
+	}
+	h.mu.RUnlock()
+
+	for _, histogram := range series {
+		histogram.mu.Lock()
+		var cumulative uint64
+		for i, upper := range histogram.buckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, histogram.labels.format("le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, histogram.labels.format("le", "+Inf"), histogram.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metric, histogram.labels.format(), formatFloat(histogram.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metric, histogram.labels.format(), histogram.count)
+		histogram.mu.Unlock()
+	}
+}
+
+// GaugeFunc 采集时通过回调取值的仪表
+type GaugeFunc struct {
+	metric string
+	help   string
+	value  func() float64
+}
+
+// RegisterGaugeFunc 注册回调仪表到默认注册表，同名仪表会被替换
+func RegisterGaugeFunc(name, help string, value func() float64) {
+	DefaultRegistry.register(&GaugeFunc{metric: name, help: help, value: value})
+}
+
+func (g *GaugeFunc) name() string { return g.metric }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metric, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metric, formatFloat(g.value()))
+}
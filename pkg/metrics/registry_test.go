@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// render 将采集器注册到独立的注册表并输出文本格式
+func render(collectors ...collector) string {
+	r := NewRegistry()
+	for _, c := range collectors {
+		r.register(c)
+	}
+	var sb strings.Builder
+	r.WriteText(&sb)
+	return sb.String()
+}
+
+func TestCounterVecText(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "测试计数器", "source", "type")
+	c.WithLabelValues("survival", "chat").Add(2)
+	c.WithLabelValues("creative", "event").Inc()
+	c.WithLabelValues("creative", "event").Add(-5) // 负数被忽略
+	c.WithLabelValues(`a"b\c`+"\n", "chat").Inc()
+
+	want := `# HELP test_counter_total 测试计数器
+# TYPE test_counter_total counter
+test_counter_total{source="a\"b\\c\n",type="chat"} 1
+test_counter_total{source="creative",type="event"} 1
+test_counter_total{source="survival",type="chat"} 2
+`
+	if got := render(c); got != want {
+		t.Errorf("输出不符\n得到:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+func TestHistogramVecText(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "测试直方图", []float64{0.1, 1}, "backend")
+	h.WithLabelValues("redis").Observe(0.05)
+	h.WithLabelValues("redis").Observe(0.5)
+	h.WithLabelValues("redis").Observe(3)
+
+	want := `# HELP test_duration_seconds 测试直方图
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{backend="redis",le="0.1"} 1
+test_duration_seconds_bucket{backend="redis",le="1"} 2
+test_duration_seconds_bucket{backend="redis",le="+Inf"} 3
+test_duration_seconds_sum{backend="redis"} 3.55
+test_duration_seconds_count{backend="redis"} 3
+`
+	if got := render(h); got != want {
+		t.Errorf("输出不符\n得到:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncText(t *testing.T) {
+	g := &GaugeFunc{metric: "test_connections", help: "测试仪表", value: func() float64 { return 3 }}
+
+	want := `# HELP test_connections 测试仪表
+# TYPE test_connections gauge
+test_connections 3
+`
+	if got := render(g); got != want {
+		t.Errorf("输出不符\n得到:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+func TestRegistryOrderAndReplace(t *testing.T) {
+	first := &GaugeFunc{metric: "test_a", help: "a", value: func() float64 { return 1 }}
+	second := &GaugeFunc{metric: "test_b", help: "b", value: func() float64 { return 2 }}
+	replaced := &GaugeFunc{metric: "test_a", help: "a", value: func() float64 { return 10 }}
+
+	got := render(first, second, replaced)
+	if strings.Count(got, "# TYPE test_a gauge") != 1 {
+		t.Fatalf("同名指标应只输出一次:\n%s", got)
+	}
+	if !strings.Contains(got, "test_a 10\n") {
+		t.Errorf("同名指标应被替换:\n%s", got)
+	}
+	if strings.Index(got, "test_a") > strings.Index(got, "test_b") {
+		t.Errorf("替换后应保持注册顺序:\n%s", got)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.0005, "0.0005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.value); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, 期望 %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	r := NewRegistry()
+	r.register(&GaugeFunc{metric: "test_up", help: "up", value: func() float64 { return 1 }})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "test_up 1\n") {
+		t.Errorf("响应缺少指标:\n%s", rec.Body.String())
+	}
+}
+
+func TestWithLabelValuesCount(t *testing.T) {
+	c := NewCounterVec("test_labels_total", "测试标签数量", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("标签值数量不符时应panic")
+		}
+	}()
+	c.WithLabelValues("only-one")
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 中间件拒绝消息的错误类型，通过errors.Is判断
+var (
+	// ErrRejected 消息不符合要求被拒绝，发送者会收到错误回复
+	ErrRejected = errors.New("消息被拒绝")
+	// ErrDropped 消息被静默丢弃，发送者仍收到正常的确认
+	ErrDropped = errors.New("消息被丢弃")
+	// ErrRateLimited 消息超出限流或发送者已被禁言
+	ErrRateLimited = errors.New("消息发送过于频繁")
+	// ErrUnauthorized 消息发送者与连接认证身份不符
+	ErrUnauthorized = errors.New("消息来源与连接认证身份不符")
+)
+
+// Error 中间件拒绝消息的原因
+type Error struct {
+	Kind   error  // ErrRejected、ErrDropped、ErrRateLimited 或 ErrUnauthorized
+	Reason string // 具体原因，与Kind的描述一起返回给客户端
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Reason
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Reject 创建拒绝错误
+func Reject(format string, args ...interface{}) error {
+	return &Error{Kind: ErrRejected, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Drop 创建静默丢弃错误，reason仅用于日志
+func Drop(format string, args ...interface{}) error {
+	return &Error{Kind: ErrDropped, Reason: fmt.Sprintf(format, args...)}
+}
+
+// RateLimited 创建限流错误
+func RateLimited(format string, args ...interface{}) error {
+	return &Error{Kind: ErrRateLimited, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Unauthorized 创建身份不符错误
+func Unauthorized(format string, args ...interface{}) error {
+	return &Error{Kind: ErrUnauthorized, Reason: fmt.Sprintf(format, args...)}
+}
@@ -3,11 +3,27 @@ package middleware
 import (
 	"GRUniChat-Broadcaster/internal/message"
 	"GRUniChat-Broadcaster/pkg/logger"
+	"errors"
+	"unicode/utf8"
 )
 
+// Context 中间件处理上下文
+type Context struct {
+	ServerID string // 发送该消息的连接通过hello认证的服务器ID
+	Replay   bool   // 回放历史消息，中间件只转换内容，不再记录审核结果或计数
+}
+
 // Middleware WebSocket消息中间件接口
+//
+// 拒绝消息时返回 *Error（见 Reject、Drop、RateLimited、Unauthorized），
+// 连接据此向发送者回复对应的错误码；返回nil消息且无错误时视为静默丢弃。
 type Middleware interface {
-	Process(msg *message.Message) (*message.Message, error)
+	Process(ctx *Context, msg *message.Message) (*message.Message, error)
+}
+
+// TargetMiddleware 路由后按目标群组处理消息的中间件，返回nil表示不发送到该群组
+type TargetMiddleware interface {
+	ProcessTarget(ctx *Context, msg *message.Message, group string) (*message.Message, error)
 }
 
 // AuthMiddleware 认证中间件
@@ -19,28 +35,49 @@ func NewAuthMiddleware(log logger.Logger) *AuthMiddleware {
 	return &AuthMiddleware{logger: log}
 }
 
-func (m *AuthMiddleware) Process(msg *message.Message) (*message.Message, error) {
+func (m *AuthMiddleware) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
 	if msg.From == "" {
 		m.logger.Error("消息缺少发送者信息")
-		return nil, nil
+		return nil, Reject("消息缺少发送者信息")
+	}
+	if ctx != nil && ctx.ServerID != "" && msg.From != ctx.ServerID {
+		m.logger.Errorf("拒绝冒充消息: 连接身份=%s, from=%s", ctx.ServerID, msg.From)
+		return nil, Unauthorized("连接身份为 %s，消息来源为 %s", ctx.ServerID, msg.From)
 	}
 	return msg, nil
 }
 
 // ValidationMiddleware 消息验证中间件
 type ValidationMiddleware struct {
-	logger logger.Logger
+	options ValidationOptions
+	logger  logger.Logger
+}
+
+// ValidationOptions 验证中间件选项
+type ValidationOptions struct {
+	RequireContent   bool `yaml:"require_content"`    // chat/command/event消息必须有内容
+	MaxContentLength int  `yaml:"max_content_length"` // 内容最大长度（字符），0表示不限制
 }
 
 func NewValidationMiddleware(log logger.Logger) *ValidationMiddleware {
 	return &ValidationMiddleware{logger: log}
 }
 
-func (m *ValidationMiddleware) Process(msg *message.Message) (*message.Message, error) {
+func (m *ValidationMiddleware) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
 	// 验证消息格式
 	if msg.Type == "" {
 		m.logger.Error("消息类型不能为空")
-		return nil, nil
+		return nil, Reject("消息类型不能为空")
+	}
+
+	content := msg.Content()
+	if m.options.RequireContent && content == "" && (msg.Type == "chat" || msg.Type == "command" || msg.Type == "event") {
+		m.logger.Errorf("消息内容不能为空: type=%s", msg.Type)
+		return nil, Reject("消息内容不能为空")
+	}
+	if m.options.MaxContentLength > 0 && utf8.RuneCountInString(content) > m.options.MaxContentLength {
+		m.logger.Errorf("消息内容超过最大长度 %d: from=%s", m.options.MaxContentLength, msg.From)
+		return nil, Reject("消息内容超过最大长度 %d", m.options.MaxContentLength)
 	}
 
 	// Body是结构体，不需要nil检查
@@ -51,15 +88,25 @@ func (m *ValidationMiddleware) Process(msg *message.Message) (*message.Message,
 
 // LoggingMiddleware 日志中间件
 type LoggingMiddleware struct {
-	logger logger.Logger
+	options LoggingOptions
+	logger  logger.Logger
+}
+
+// LoggingOptions 日志中间件选项
+type LoggingOptions struct {
+	Level string `yaml:"level"` // 日志级别: debug（默认）或 info
 }
 
 func NewLoggingMiddleware(log logger.Logger) *LoggingMiddleware {
 	return &LoggingMiddleware{logger: log}
 }
 
-func (m *LoggingMiddleware) Process(msg *message.Message) (*message.Message, error) {
-	m.logger.Debugf("处理消息: from=%s, type=%s", msg.From, msg.Type)
+func (m *LoggingMiddleware) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
+	if m.options.Level == "info" {
+		m.logger.Infof("处理消息: from=%s, type=%s, %s", msg.From, msg.Type, msg.GetContent())
+	} else {
+		m.logger.Debugf("处理消息: from=%s, type=%s", msg.From, msg.Type)
+	}
 	return msg, nil
 }
 
@@ -80,7 +127,7 @@ func (c *MiddlewareChain) Add(middleware Middleware) {
 	c.middlewares = append(c.middlewares, middleware)
 }
 
-func (c *MiddlewareChain) Process(msg *message.Message) (*message.Message, error) {
+func (c *MiddlewareChain) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
 	current := msg
 	var err error
 
@@ -88,7 +135,34 @@ func (c *MiddlewareChain) Process(msg *message.Message) (*message.Message, error
 		if current == nil {
 			break
 		}
-		current, err = middleware.Process(current)
+		current, err = middleware.Process(ctx, current)
+		if err != nil {
+			if errors.Is(err, ErrDropped) {
+				c.logger.Debugf("消息被中间件丢弃: %v", err)
+			} else {
+				c.logger.Errorf("中间件处理失败: %v", err)
+			}
+			return nil, err
+		}
+	}
+
+	return current, nil
+}
+
+// ProcessTarget 对发往指定群组的消息依次执行实现了TargetMiddleware的中间件
+func (c *MiddlewareChain) ProcessTarget(ctx *Context, msg *message.Message, group string) (*message.Message, error) {
+	current := msg
+	var err error
+
+	for _, middleware := range c.middlewares {
+		target, ok := middleware.(TargetMiddleware)
+		if !ok {
+			continue
+		}
+		if current == nil {
+			break
+		}
+		current, err = target.ProcessTarget(ctx, current, group)
 		if err != nil {
 			c.logger.Errorf("中间件处理失败: %v", err)
 			return nil, err
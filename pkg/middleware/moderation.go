@@ -0,0 +1,325 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+	"GRUniChat-Broadcaster/pkg/metrics"
+	"GRUniChat-Broadcaster/pkg/utils"
+)
+
+// 内容审核动作
+const (
+	ModerationMask = "mask" // 屏蔽命中的词
+	ModerationDrop = "drop" // 不发送到该群组
+	ModerationNone = "none" // 不处理
+)
+
+// ModerationOptions 内容审核中间件选项
+type ModerationOptions struct {
+	Words           []string          `yaml:"words"`            // 屏蔽词
+	WordFiles       []string          `yaml:"word_files"`       // 屏蔽词文件，每行一个词，#开头为注释
+	Patterns        []string          `yaml:"patterns"`         // 正则表达式
+	Mask            string            `yaml:"mask"`             // 替换文本，单个字符时按命中长度重复
+	CaseInsensitive bool              `yaml:"case_insensitive"` // 忽略大小写
+	NormalizeWidth  bool              `yaml:"normalize_width"`  // 全角字符按半角匹配
+	MessageTypes    []string          `yaml:"message_types"`    // 需要审核的消息类型
+	DefaultAction   string            `yaml:"default_action"`   // 默认动作: mask, drop, none
+	Groups          map[string]string `yaml:"groups"`           // 按目标群组覆盖动作
+	Record          bool              `yaml:"record"`           // 是否将审核记录写入消息存储
+}
+
+// ModerationRecord 写入消息存储的审核记录
+type ModerationRecord struct {
+	TotalID  string   `json:"totalId"`
+	From     string   `json:"from"`
+	Sender   string   `json:"sender"`
+	Group    string   `json:"group"`
+	Action   string   `json:"action"`
+	Matches  []string `json:"matches"`
+	Original string   `json:"original"`
+	Masked   string   `json:"masked,omitempty"`
+	Time     string   `json:"time"`
+}
+
+// ModerationMiddleware 内容审核中间件，在路由后按目标群组屏蔽或拦截命中的内容
+type ModerationMiddleware struct {
+	options  ModerationOptions
+	matchers []*regexp.Regexp
+	store    database.MessageStoreInterface
+	ttl      time.Duration
+	logger   logger.Logger
+}
+
+// NewModerationMiddleware 创建内容审核中间件，加载词表并编译匹配规则
+func NewModerationMiddleware(opts ModerationOptions, store database.MessageStoreInterface, ttl time.Duration, log logger.Logger) (*ModerationMiddleware, error) {
+	if opts.Mask == "" {
+		opts.Mask = "***"
+	}
+	if opts.DefaultAction == "" {
+		opts.DefaultAction = ModerationMask
+	}
+	if len(opts.MessageTypes) == 0 {
+		opts.MessageTypes = []string{"chat"}
+	}
+	if !isValidModerationAction(opts.DefaultAction) {
+		return nil, fmt.Errorf("不支持的审核动作: %s", opts.DefaultAction)
+	}
+	for group, action := range opts.Groups {
+		if !isValidModerationAction(action) {
+			return nil, fmt.Errorf("群组 '%s' 的审核动作无效: %s", group, action)
+		}
+	}
+
+	words := append([]string{}, opts.Words...)
+	for _, file := range opts.WordFiles {
+		loaded, err := loadWordFile(file)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, loaded...)
+	}
+
+	m := &ModerationMiddleware{
+		options: opts,
+		store:   store,
+		ttl:     ttl,
+		logger:  log,
+	}
+
+	// 词表合并为一个正则，较长的词优先匹配
+	if normalized := m.normalizeWords(words); len(normalized) > 0 {
+		quoted := make([]string, len(normalized))
+		for i, word := range normalized {
+			quoted[i] = regexp.QuoteMeta(word)
+		}
+		m.matchers = append(m.matchers, regexp.MustCompile(strings.Join(quoted, "|")))
+	}
+	for _, pattern := range opts.Patterns {
+		if opts.CaseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("审核正则 '%s' 无效: %v", pattern, err)
+		}
+		m.matchers = append(m.matchers, re)
+	}
+
+	log.Infof("内容审核已加载 %d 个屏蔽词, %d 条正则", len(words), len(opts.Patterns))
+	return m, nil
+}
+
+// Process 路由前不做处理，审核在ProcessTarget中按目标群组进行
+func (m *ModerationMiddleware) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
+	return msg, nil
+}
+
+// ProcessTarget 按目标群组的动作屏蔽或拦截消息，返回nil表示不发送到该群组
+func (m *ModerationMiddleware) ProcessTarget(ctx *Context, msg *message.Message, group string) (*message.Message, error) {
+	if len(m.matchers) == 0 || !utils.Contains(m.options.MessageTypes, msg.Type) {
+		return msg, nil
+	}
+
+	action := m.options.DefaultAction
+	if override, exists := m.options.Groups[group]; exists {
+		action = override
+	}
+	if action == ModerationNone {
+		return msg, nil
+	}
+
+	content := msg.Content()
+	masked, matches := m.mask(content)
+	if len(matches) == 0 {
+		return msg, nil
+	}
+
+	// 回放历史消息时只按相同动作处理内容，审核结果已在实时广播时记录过
+	if ctx == nil || !ctx.Replay {
+		m.logger.Infof("内容审核: 消息 %s 命中 %v，群组 '%s' 执行 %s", msg.TotalID, matches, group, action)
+		metrics.ModerationActions.WithLabelValues(group, action).Inc()
+
+		record := ModerationRecord{
+			TotalID:  msg.TotalID,
+			From:     msg.From,
+			Sender:   msg.Body.Sender,
+			Group:    group,
+			Action:   action,
+			Matches:  matches,
+			Original: content,
+			Time:     time.Now().Format("2006-01-02 15:04:05"),
+		}
+		if action == ModerationMask {
+			record.Masked = masked
+		}
+		m.record(record)
+	}
+
+	if action == ModerationDrop {
+		return nil, nil
+	}
+
+	moderated := msg.Clone()
+	moderated.SetContent(masked)
+	return moderated, nil
+}
+
+// mask 屏蔽命中的内容，返回屏蔽后的文本和命中的原文片段
+func (m *ModerationMiddleware) mask(text string) (string, []string) {
+	if text == "" {
+		return text, nil
+	}
+
+	original := []rune(text)
+	normalized := m.normalize(text)
+
+	// 归一化逐字符进行，字节偏移需转换为字符下标以对应原文
+	runeIndex := make([]int, len(normalized)+1)
+	pos := 0
+	for i := range original {
+		runeIndex[pos] = i
+		_, size := utf8.DecodeRuneInString(normalized[pos:])
+		pos += size
+	}
+	runeIndex[pos] = len(original)
+
+	marked := make([]bool, len(original))
+	seen := make(map[string]bool)
+	var matches []string
+	for _, re := range m.matchers {
+		for _, loc := range re.FindAllStringIndex(normalized, -1) {
+			start, end := runeIndex[loc[0]], runeIndex[loc[1]]
+			if start == end {
+				continue
+			}
+			for i := start; i < end; i++ {
+				marked[i] = true
+			}
+			if hit := string(original[start:end]); !seen[hit] {
+				seen[hit] = true
+				matches = append(matches, hit)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return text, nil
+	}
+
+	maskRunes := []rune(m.options.Mask)
+	var sb strings.Builder
+	for i := 0; i < len(original); {
+		if !marked[i] {
+			sb.WriteRune(original[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(original) && marked[j] {
+			j++
+		}
+		if len(maskRunes) == 1 {
+			sb.WriteString(strings.Repeat(m.options.Mask, j-i))
+		} else {
+			sb.WriteString(m.options.Mask)
+		}
+		i = j
+	}
+	return sb.String(), matches
+}
+
+// normalize 逐字符归一化：全角转半角、转小写，保证与原文字符一一对应
+func (m *ModerationMiddleware) normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if m.options.NormalizeWidth {
+			r = toHalfWidth(r)
+		}
+		if m.options.CaseInsensitive {
+			r = unicode.ToLower(r)
+		}
+		return r
+	}, text)
+}
+
+// normalizeWords 归一化并去重屏蔽词，按长度降序排列
+func (m *ModerationMiddleware) normalizeWords(words []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, word := range words {
+		word = m.normalize(strings.TrimSpace(word))
+		if word != "" && !seen[word] {
+			seen[word] = true
+			result = append(result, word)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return utf8.RuneCountInString(result[i]) > utf8.RuneCountInString(result[j])
+	})
+	return result
+}
+
+// record 将审核记录写入消息存储的审核记录中，不计入消息统计
+func (m *ModerationMiddleware) record(record ModerationRecord) {
+	if !m.options.Record || m.store == nil {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := m.store.SetModerationRecord(record.TotalID, record.Group, data, m.ttl); err != nil {
+		m.logger.Errorf("保存审核记录失败: %v", err)
+	}
+	m.store.IncrementCounter("moderation:" + record.Action)
+}
+
+// toHalfWidth 全角字符转换为对应的半角字符
+func toHalfWidth(r rune) rune {
+	switch {
+	case r == '　':
+		return ' '
+	case r >= '！' && r <= '～':
+		return r - 0xFEE0
+	}
+	return r
+}
+
+// loadWordFile 加载屏蔽词文件
+func loadWordFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开屏蔽词文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取屏蔽词文件失败: %v", err)
+	}
+	return words, nil
+}
+
+// isValidModerationAction 检查审核动作是否有效
+func isValidModerationAction(action string) bool {
+	return action == ModerationMask || action == ModerationDrop || action == ModerationNone
+}
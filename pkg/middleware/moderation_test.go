@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/database"
+)
+
+// newTestModeration 创建使用内存存储的内容审核中间件
+func newTestModeration(t *testing.T, opts ModerationOptions) (*ModerationMiddleware, database.MessageStoreInterface) {
+	t.Helper()
+	store := database.NewMemoryStore(&config.MemoryConfig{MaxEntries: 100, MaxBytes: 1 << 20, CleanupInterval: 60})
+	t.Cleanup(func() { store.Close() })
+	m, err := NewModerationMiddleware(opts, store, time.Hour, nopLogger{})
+	if err != nil {
+		t.Fatalf("创建内容审核中间件失败: %v", err)
+	}
+	return m, store
+}
+
+// chatMessage 创建一条聊天消息
+func chatMessage(totalID, content string) *message.Message {
+	return &message.Message{From: "survival", Type: "chat", TotalID: totalID, Body: message.Body{Sender: "Steve", ChatMessage: content}}
+}
+
+func TestModerationMaskOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ModerationOptions
+		content string
+		want    string
+		matches []string
+	}{
+		{"默认替换文本", ModerationOptions{Words: []string{"bad"}}, "a bad word", "a *** word", []string{"bad"}},
+		{"单字符按长度重复", ModerationOptions{Words: []string{"坏话"}, Mask: "*"}, "不要说坏话", "不要说**", []string{"坏话"}},
+		{"较长的词优先", ModerationOptions{Words: []string{"bad", "badword"}, Mask: "#"}, "badword!", "#######!", []string{"badword"}},
+		{"相邻命中合并", ModerationOptions{Words: []string{"ab", "cd"}, Mask: "[x]"}, "abcd e", "[x] e", []string{"ab", "cd"}},
+		{"忽略大小写并保留原文", ModerationOptions{Words: []string{"bad"}, CaseInsensitive: true, Mask: "*"}, "BaD!", "***!", []string{"BaD"}},
+		{"全角按半角匹配", ModerationOptions{Words: []string{"bad"}, NormalizeWidth: true, Mask: "*"}, "ｂａｄ运气", "***运气", []string{"ｂａｄ"}},
+		{"正则", ModerationOptions{Patterns: []string{`\d{6,}`}, Mask: "*"}, "加群123456789", "加群*********", []string{"123456789"}},
+		{"未命中保持原样", ModerationOptions{Words: []string{"bad"}}, "good", "good", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestModeration(t, tt.opts)
+			got, matches := m.mask(tt.content)
+			if got != tt.want || !reflect.DeepEqual(matches, tt.matches) {
+				t.Errorf("mask(%q) = %q, %v, 期望 %q, %v", tt.content, got, matches, tt.want, tt.matches)
+			}
+		})
+	}
+}
+
+func TestModerationActionPerGroup(t *testing.T) {
+	m, _ := newTestModeration(t, ModerationOptions{
+		Words:  []string{"badword"},
+		Mask:   "*",
+		Groups: map[string]string{"QQ群": ModerationDrop, "管理群": ModerationNone},
+	})
+	msg := chatMessage("msg-1", "this badword here")
+
+	// 默认动作屏蔽，返回副本，原消息不变
+	masked, err := m.ProcessTarget(nil, msg, "游戏服")
+	if err != nil || masked == nil || masked.Content() != "this ******* here" {
+		t.Fatalf("游戏服 = %v, %v, 期望屏蔽后的内容", masked, err)
+	}
+	if msg.Content() != "this badword here" {
+		t.Errorf("原消息被修改: %q", msg.Content())
+	}
+
+	if dropped, err := m.ProcessTarget(nil, msg, "QQ群"); err != nil || dropped != nil {
+		t.Errorf("QQ群 = %v, %v, 期望不发送", dropped, err)
+	}
+	if kept, err := m.ProcessTarget(nil, msg, "管理群"); err != nil || kept != msg {
+		t.Errorf("管理群 = %v, %v, 期望原样发送", kept, err)
+	}
+
+	// 不在审核类型中的消息不处理
+	event := &message.Message{From: "survival", Type: "event", Body: message.Body{EventDetail: "badword"}}
+	if got, _ := m.ProcessTarget(nil, event, "QQ群"); got != event {
+		t.Errorf("event消息应不经审核，得到 %v", got)
+	}
+}
+
+func TestModerationRecords(t *testing.T) {
+	m, store := newTestModeration(t, ModerationOptions{
+		Words:  []string{"badword"},
+		Mask:   "*",
+		Groups: map[string]string{"QQ群": ModerationDrop},
+		Record: true,
+	})
+	msg := chatMessage("msg-1", "badword!")
+	m.ProcessTarget(nil, msg, "游戏服")
+	m.ProcessTarget(nil, msg, "QQ群")
+
+	records, err := store.GetModerationRecords("msg-1")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("审核记录 = %v, %v, 期望每个群组一条", records, err)
+	}
+	for group, want := range map[string]ModerationRecord{
+		"游戏服": {TotalID: "msg-1", From: "survival", Sender: "Steve", Group: "游戏服", Action: ModerationMask, Matches: []string{"badword"}, Original: "badword!", Masked: "*******!"},
+		"QQ群": {TotalID: "msg-1", From: "survival", Sender: "Steve", Group: "QQ群", Action: ModerationDrop, Matches: []string{"badword"}, Original: "badword!"},
+	} {
+		var got ModerationRecord
+		if err := json.Unmarshal(records[group], &got); err != nil {
+			t.Fatalf("%s 的审核记录无效: %v", group, err)
+		}
+		got.Time = ""
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s 的审核记录 = %+v, 期望 %+v", group, got, want)
+		}
+	}
+	for action, want := range map[string]int64{ModerationMask: 1, ModerationDrop: 1} {
+		if value, _ := store.GetCounter("moderation:" + action); value != want {
+			t.Errorf("moderation:%s = %d, 期望 %d", action, value, want)
+		}
+	}
+
+	// 回放历史消息时照常屏蔽，但不再写入记录或计数
+	replayed := chatMessage("msg-2", "badword!")
+	if got, _ := m.ProcessTarget(&Context{Replay: true}, replayed, "游戏服"); got == nil || got.Content() != "*******!" {
+		t.Errorf("回放时应照常屏蔽，得到 %v", got)
+	}
+	if records, _ := store.GetModerationRecords("msg-2"); len(records) != 0 {
+		t.Errorf("回放不应写入审核记录: %v", records)
+	}
+	if value, _ := store.GetCounter("moderation:" + ModerationMask); value != 1 {
+		t.Errorf("回放不应计数, moderation:mask = %d", value)
+	}
+}
+
+func TestModerationRecordDisabled(t *testing.T) {
+	m, store := newTestModeration(t, ModerationOptions{Words: []string{"badword"}})
+	m.ProcessTarget(nil, chatMessage("msg-1", "badword"), "游戏服")
+
+	if records, _ := store.GetModerationRecords("msg-1"); len(records) != 0 {
+		t.Errorf("未启用record时不应写入审核记录: %v", records)
+	}
+}
+
+func TestModerationInvalidAction(t *testing.T) {
+	if _, err := NewModerationMiddleware(ModerationOptions{Groups: map[string]string{"QQ群": "ban"}}, nil, time.Hour, nopLogger{}); err == nil {
+		t.Error("无效的群组动作应返回错误")
+	}
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// 长时间未使用且已补满的令牌桶会被清理
+const (
+	bucketIdleTimeout = 10 * time.Minute
+	pruneInterval     = time.Minute
+)
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill 按limit补充令牌，返回桶中是否至少有一个令牌
+func (b *tokenBucket) refill(limit config.RateLimit, now time.Time) bool {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	b.last = now
+	return b.tokens >= 1
+}
+
+// offender 超限记录
+type offender struct {
+	violations  int
+	windowStart time.Time
+	mutedUntil  time.Time
+}
+
+// limitCheck 单项限流检查
+type limitCheck struct {
+	key      string // 令牌桶键
+	limit    config.RateLimit
+	offender string // 超限时计入的禁言对象
+	desc     string // 日志和错误中使用的描述
+}
+
+// RateLimitMiddleware 令牌桶限流中间件，按服务器ID、发送者和消息类型分别限流
+type RateLimitMiddleware struct {
+	config    config.RateLimitConfig
+	buckets   map[string]*tokenBucket
+	offenders map[string]*offender
+	lastPrune time.Time
+	now       func() time.Time // 当前时间，测试时可替换
+	logger    logger.Logger
+	mu        sync.Mutex
+}
+
+// NewRateLimitMiddleware 创建限流中间件
+func NewRateLimitMiddleware(cfg *config.RateLimitConfig, log logger.Logger) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		config:    *cfg,
+		buckets:   make(map[string]*tokenBucket),
+		offenders: make(map[string]*offender),
+		lastPrune: time.Now(),
+		now:       time.Now,
+		logger:    log,
+	}
+}
+
+// UpdateConfig 更新限流配置（用于热重载），已有的令牌桶和禁言状态保留
+func (m *RateLimitMiddleware) UpdateConfig(cfg *config.RateLimitConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config = *cfg
+}
+
+func (m *RateLimitMiddleware) Process(ctx *Context, msg *message.Message) (*message.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.config.Enabled {
+		return msg, nil
+	}
+
+	serverID := msg.From
+	if ctx != nil && ctx.ServerID != "" {
+		serverID = ctx.ServerID
+	}
+	now := m.now()
+	m.pruneLocked(now)
+
+	serverKey := "server:" + serverID
+	senderKey := ""
+	if msg.Body.Sender != "" {
+		senderKey = "sender:" + serverID + "/" + msg.Body.Sender
+	}
+
+	// 已被禁言的服务器或发送者直接拒绝
+	for _, key := range []string{serverKey, senderKey} {
+		if key == "" {
+			continue
+		}
+		if o, exists := m.offenders[key]; exists && now.Before(o.mutedUntil) {
+			return nil, RateLimited("已被禁言，%d 秒后解除", int(math.Ceil(o.mutedUntil.Sub(now).Seconds())))
+		}
+	}
+
+	serverLimit := m.config.PerServer
+	if override, exists := m.config.Servers[serverID]; exists {
+		serverLimit = override
+	}
+
+	checks := []limitCheck{
+		{serverKey, serverLimit, serverKey, "服务器 " + serverID},
+		{"type:" + serverID + "/" + msg.Type, m.config.PerType[msg.Type], serverKey, "消息类型 " + msg.Type},
+	}
+	if senderKey != "" {
+		checks = append(checks, limitCheck{senderKey, m.config.PerSender, senderKey, "发送者 " + msg.Body.Sender})
+	}
+
+	// 先确认所有令牌桶都有令牌再统一扣除，被拒绝的消息不消耗任何桶的令牌，
+	// 否则单个超限的发送者会耗尽整个服务器的额度
+	buckets := make([]*tokenBucket, 0, len(checks))
+	for _, check := range checks {
+		if check.limit.Rate <= 0 {
+			continue
+		}
+		bucket := m.bucketLocked(check.key, check.limit, now)
+		if bucket.refill(check.limit, now) {
+			buckets = append(buckets, bucket)
+			continue
+		}
+
+		m.logger.Infof("限流: %s 超出限制 (%.2f/s, burst %d)", check.desc, check.limit.Rate, check.limit.Burst)
+		if m.recordViolationLocked(check.offender, now) {
+			m.logger.Infof("限流: %s 多次超限，禁言 %d 秒", check.desc, m.config.Mute.Duration)
+			return nil, RateLimited("%s 多次超限，已被禁言 %d 秒", check.desc, m.config.Mute.Duration)
+		}
+		return nil, RateLimited("%s 超出限制", check.desc)
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return msg, nil
+}
+
+// bucketLocked 获取令牌桶，不存在时创建一个满桶，调用方需持有锁
+func (m *RateLimitMiddleware) bucketLocked(key string, limit config.RateLimit, now time.Time) *tokenBucket {
+	bucket, exists := m.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// recordViolationLocked 记录一次超限，返回是否因此被禁言，调用方需持有锁
+func (m *RateLimitMiddleware) recordViolationLocked(key string, now time.Time) bool {
+	mute := m.config.Mute
+	if mute.Threshold <= 0 {
+		return false
+	}
+
+	o, exists := m.offenders[key]
+	if !exists || now.Sub(o.windowStart) > time.Duration(mute.Window)*time.Second {
+		o = &offender{windowStart: now}
+		m.offenders[key] = o
+	}
+
+	o.violations++
+	if o.violations < mute.Threshold {
+		return false
+	}
+
+	o.mutedUntil = now.Add(time.Duration(mute.Duration) * time.Second)
+	o.violations = 0
+	o.windowStart = now
+	return true
+}
+
+// pruneLocked 定期清理闲置的令牌桶和过期的超限记录，调用方需持有锁
+func (m *RateLimitMiddleware) pruneLocked(now time.Time) {
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.last) > bucketIdleTimeout {
+			delete(m.buckets, key)
+		}
+	}
+
+	window := time.Duration(m.config.Mute.Window) * time.Second
+	for key, o := range m.offenders {
+		if now.After(o.mutedUntil) && now.Sub(o.windowStart) > window {
+			delete(m.offenders, key)
+		}
+	}
+}
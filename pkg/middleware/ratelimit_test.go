@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+)
+
+// nopLogger 丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Info(v ...interface{})                  {}
+func (nopLogger) Infof(format string, v ...interface{})  {}
+func (nopLogger) Error(v ...interface{})                 {}
+func (nopLogger) Errorf(format string, v ...interface{}) {}
+func (nopLogger) Debug(v ...interface{})                 {}
+func (nopLogger) Debugf(format string, v ...interface{}) {}
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestRateLimit 创建使用fakeClock的限流中间件
+func newTestRateLimit(cfg config.RateLimitConfig) (*RateLimitMiddleware, *fakeClock) {
+	cfg.Enabled = true
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewRateLimitMiddleware(&cfg, nopLogger{})
+	m.now = clock.Now
+	m.lastPrune = clock.now
+	return m, clock
+}
+
+// send 以serverID的身份发送一条消息，返回是否通过
+func send(t *testing.T, m *RateLimitMiddleware, serverID, sender, msgType string) error {
+	t.Helper()
+	msg := &message.Message{From: serverID, Type: msgType, Body: message.Body{Sender: sender}}
+	out, err := m.Process(&Context{ServerID: serverID}, msg)
+	if err == nil && out != msg {
+		t.Fatalf("通过的消息应原样返回")
+	}
+	if err != nil && !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("期望限流错误，得到 %v", err)
+	}
+	return err
+}
+
+// passes 连续发送n条消息，返回通过的条数
+func passes(t *testing.T, m *RateLimitMiddleware, n int, serverID, sender, msgType string) int {
+	t.Helper()
+	passed := 0
+	for i := 0; i < n; i++ {
+		if send(t, m, serverID, sender, msgType) == nil {
+			passed++
+		}
+	}
+	return passed
+}
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	m, clock := newTestRateLimit(config.RateLimitConfig{PerServer: config.RateLimit{Rate: 2, Burst: 5}})
+
+	if got := passes(t, m, 8, "survival", "", "chat"); got != 5 {
+		t.Fatalf("满桶时通过 %d 条, 期望burst=5", got)
+	}
+
+	clock.Advance(time.Second)
+	if got := passes(t, m, 5, "survival", "", "chat"); got != 2 {
+		t.Fatalf("1秒后通过 %d 条, 期望补充2个令牌", got)
+	}
+
+	// 补充的令牌不超过桶容量
+	clock.Advance(time.Minute)
+	if got := passes(t, m, 8, "survival", "", "chat"); got != 5 {
+		t.Fatalf("长时间空闲后通过 %d 条, 期望不超过burst=5", got)
+	}
+}
+
+func TestRateLimitPerServerOverride(t *testing.T) {
+	m, _ := newTestRateLimit(config.RateLimitConfig{
+		PerServer: config.RateLimit{Rate: 1, Burst: 1},
+		Servers:   map[string]config.RateLimit{"qq_bot": {Rate: 10, Burst: 10}},
+	})
+
+	if got := passes(t, m, 3, "survival", "", "chat"); got != 1 {
+		t.Errorf("survival 通过 %d 条, 期望默认burst=1", got)
+	}
+	if got := passes(t, m, 12, "qq_bot", "", "chat"); got != 10 {
+		t.Errorf("qq_bot 通过 %d 条, 期望覆盖后的burst=10", got)
+	}
+}
+
+func TestRateLimitPerType(t *testing.T) {
+	m, _ := newTestRateLimit(config.RateLimitConfig{
+		PerServer: config.RateLimit{Rate: 100, Burst: 100},
+		PerType:   map[string]config.RateLimit{"command": {Rate: 1, Burst: 2}},
+	})
+
+	if got := passes(t, m, 4, "survival", "", "command"); got != 2 {
+		t.Errorf("command 通过 %d 条, 期望2", got)
+	}
+	if got := passes(t, m, 4, "survival", "", "chat"); got != 4 {
+		t.Errorf("未配置类型限制的chat 通过 %d 条, 期望不受command限制", got)
+	}
+}
+
+func TestRateLimitRejectedSenderKeepsServerBudget(t *testing.T) {
+	m, _ := newTestRateLimit(config.RateLimitConfig{
+		PerServer: config.RateLimit{Rate: 1, Burst: 5},
+		PerSender: config.RateLimit{Rate: 1, Burst: 1},
+	})
+
+	// Steve只有1个令牌，之后的消息被发送者限制拒绝，不应消耗服务器的令牌
+	if got := passes(t, m, 10, "survival", "Steve", "chat"); got != 1 {
+		t.Fatalf("Steve 通过 %d 条, 期望1", got)
+	}
+	for _, sender := range []string{"Alex", "Bob", "Carol", "Dave"} {
+		if err := send(t, m, "survival", sender, "chat"); err != nil {
+			t.Errorf("%s 被拒绝: %v, 期望服务器剩余的4个令牌都可用", sender, err)
+		}
+	}
+	if send(t, m, "survival", "Eve", "chat") == nil {
+		t.Error("服务器令牌用完后应被拒绝")
+	}
+}
+
+func TestRateLimitAutoMute(t *testing.T) {
+	m, clock := newTestRateLimit(config.RateLimitConfig{
+		PerServer: config.RateLimit{Rate: 100, Burst: 100},
+		PerSender: config.RateLimit{Rate: 1, Burst: 1},
+		Mute:      config.MuteConfig{Threshold: 3, Window: 60, Duration: 30},
+	})
+
+	if send(t, m, "survival", "Steve", "chat") != nil {
+		t.Fatal("第一条消息应通过")
+	}
+	// 窗口内第3次超限时禁言
+	for i := 0; i < 3; i++ {
+		if send(t, m, "survival", "Steve", "chat") == nil {
+			t.Fatalf("第%d次超限的消息应被拒绝", i+1)
+		}
+	}
+
+	// 禁言期间即使令牌已补满也被拒绝，其他发送者不受影响
+	clock.Advance(10 * time.Second)
+	if err := send(t, m, "survival", "Steve", "chat"); err == nil {
+		t.Fatal("禁言期间的消息应被拒绝")
+	}
+	if send(t, m, "survival", "Alex", "chat") != nil {
+		t.Error("其他发送者不应被禁言")
+	}
+
+	clock.Advance(21 * time.Second)
+	if err := send(t, m, "survival", "Steve", "chat"); err != nil {
+		t.Errorf("禁言%d秒后应解除，得到 %v", 30, err)
+	}
+}
+
+func TestRateLimitViolationsOutsideWindow(t *testing.T) {
+	m, clock := newTestRateLimit(config.RateLimitConfig{
+		PerServer: config.RateLimit{Rate: 100, Burst: 100},
+		PerSender: config.RateLimit{Rate: 0.01, Burst: 1},
+		Mute:      config.MuteConfig{Threshold: 2, Window: 10, Duration: 30},
+	})
+
+	send(t, m, "survival", "Steve", "chat")
+	send(t, m, "survival", "Steve", "chat") // 第1次超限
+	clock.Advance(11 * time.Second)
+	send(t, m, "survival", "Steve", "chat") // 窗口已过，重新计数
+
+	if o := m.offenders["sender:survival/Steve"]; o == nil || !o.mutedUntil.IsZero() {
+		t.Errorf("窗口外的超限不应累计到禁言阈值: %+v", o)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	m, _ := newTestRateLimit(config.RateLimitConfig{PerServer: config.RateLimit{Rate: 1, Burst: 1}})
+	m.UpdateConfig(&config.RateLimitConfig{Enabled: false, PerServer: config.RateLimit{Rate: 1, Burst: 1}})
+
+	if got := passes(t, m, 5, "survival", "", "chat"); got != 5 {
+		t.Errorf("关闭限流后通过 %d 条, 期望全部通过", got)
+	}
+}
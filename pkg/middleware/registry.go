@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/pkg/database"
+	"GRUniChat-Broadcaster/pkg/logger"
+)
+
+// Factory 根据配置项创建中间件
+type Factory func(entry config.MiddlewareConfig) (Middleware, error)
+
+// Registry 按名称注册的中间件工厂
+type Registry struct {
+	factories map[string]Factory
+	mu        sync.RWMutex
+}
+
+// NewRegistry 创建空的中间件注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]Factory),
+	}
+}
+
+// Register 注册中间件工厂，同名工厂会被替换
+func (r *Registry) Register(name string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// Names 获取已注册的中间件名称（排序）
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build 按配置顺序创建中间件链，任一中间件未注册或创建失败都会返回错误
+func (r *Registry) Build(entries []config.MiddlewareConfig, log logger.Logger) (*MiddlewareChain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chain := NewMiddlewareChain(log)
+	for _, entry := range entries {
+		factory, exists := r.factories[entry.Name]
+		if !exists {
+			return nil, fmt.Errorf("未知的中间件: %s", entry.Name)
+		}
+		mw, err := factory(entry)
+		if err != nil {
+			return nil, fmt.Errorf("创建中间件 '%s' 失败: %v", entry.Name, err)
+		}
+		chain.Add(mw)
+	}
+	return chain, nil
+}
+
+// Dependencies 内置中间件依赖的共享组件
+type Dependencies struct {
+	RateLimiter *RateLimitMiddleware           // 跨热重载共享的限流实例
+	Store       database.MessageStoreInterface // 审核记录写入的消息存储
+	TTL         time.Duration                  // 审核记录的过期时间
+	Logger      logger.Logger
+}
+
+// RegisterBuiltins 注册内置中间件：auth、rate_limit、validation、logging、moderation
+//
+// rate_limit 使用传入的共享实例，配置来自顶层的 rate_limit 节，以便热重载时保留令牌桶状态。
+func RegisterBuiltins(r *Registry, deps Dependencies) {
+	log := deps.Logger
+	rateLimiter := deps.RateLimiter
+	r.Register("auth", func(entry config.MiddlewareConfig) (Middleware, error) {
+		return NewAuthMiddleware(log), nil
+	})
+	r.Register("rate_limit", func(entry config.MiddlewareConfig) (Middleware, error) {
+		return rateLimiter, nil
+	})
+	r.Register("validation", func(entry config.MiddlewareConfig) (Middleware, error) {
+		m := NewValidationMiddleware(log)
+		if err := entry.DecodeOptions(&m.options); err != nil {
+			return nil, err
+		}
+		if m.options.MaxContentLength < 0 {
+			return nil, fmt.Errorf("max_content_length不能为负数")
+		}
+		return m, nil
+	})
+	r.Register("logging", func(entry config.MiddlewareConfig) (Middleware, error) {
+		m := NewLoggingMiddleware(log)
+		if err := entry.DecodeOptions(&m.options); err != nil {
+			return nil, err
+		}
+		switch m.options.Level {
+		case "", "debug", "info":
+		default:
+			return nil, fmt.Errorf("不支持的日志级别: %s", m.options.Level)
+		}
+		return m, nil
+	})
+	r.Register("moderation", func(entry config.MiddlewareConfig) (Middleware, error) {
+		var opts ModerationOptions
+		if err := entry.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return NewModerationMiddleware(opts, deps.Store, deps.TTL, log)
+	})
+}
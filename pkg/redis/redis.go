@@ -1,7 +1,7 @@
 package redis
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -11,32 +11,55 @@ import (
 	"sync"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"GRUniChat-Broadcaster/pkg/logger"
 )
 
-// EmbeddedRedis 内嵌Redis服务器
+// ErrServerNotFound 未找到redis-server可执行文件
+var ErrServerNotFound = errors.New("未找到Redis服务器可执行文件")
+
+const (
+	startTimeout      = 30 * time.Second // 等待Redis开始监听的最长时间
+	stopTimeout       = 10 * time.Second // 等待Redis保存数据并退出的最长时间
+	maxRestartBackoff = 30 * time.Second // 连续重启失败时的最长等待间隔
+)
+
+// EmbeddedRedis 内嵌Redis服务器，进程意外退出后会自动重启
 type EmbeddedRedis struct {
-	port    int
-	dataDir string
-	cmd     *exec.Cmd
-	client  *redis.Client
-	ctx     context.Context
-	cancel  context.CancelFunc
+	port       int
+	dataDir    string
+	maxMemory  string
+	serverPath string
+	configPath string
+	logger     logger.Logger
+	cmd        *exec.Cmd
+	exited     chan struct{} // 当前进程退出后关闭
+	restarts   int
+	stopped    bool
+	stop       chan struct{}
+	supervised chan struct{} // 守护协程退出后关闭，未开始守护时为nil
+	mu         sync.Mutex
 }
 
-// NewEmbeddedRedis 创建新的内嵌Redis实例
-func NewEmbeddedRedis(port int, dataDir string) *EmbeddedRedis {
-	ctx, cancel := context.WithCancel(context.Background())
+// NewEmbeddedRedis 创建新的内嵌Redis实例，maxMemory为Redis的maxmemory配置（如128mb）
+func NewEmbeddedRedis(port int, dataDir, maxMemory string, log logger.Logger) *EmbeddedRedis {
 	return &EmbeddedRedis{
-		port:    port,
-		dataDir: dataDir,
-		ctx:     ctx,
-		cancel:  cancel,
+		port:      port,
+		dataDir:   dataDir,
+		maxMemory: maxMemory,
+		logger:    log,
+		stop:      make(chan struct{}),
 	}
 }
 
-// Start 启动内嵌Redis服务器
+// Start 启动内嵌Redis服务器并开始守护进程，未找到可执行文件时返回ErrServerNotFound
 func (r *EmbeddedRedis) Start() error {
+	// redis-server在数据目录中运行，配置中的路径需要是绝对路径
+	dataDir, err := filepath.Abs(r.dataDir)
+	if err != nil {
+		return fmt.Errorf("解析Redis数据目录失败: %v", err)
+	}
+	r.dataDir = dataDir
+
 	// 确保数据目录存在
 	if err := os.MkdirAll(r.dataDir, 0755); err != nil {
 		return fmt.Errorf("创建Redis数据目录失败: %v", err)
@@ -50,75 +73,168 @@ func (r *EmbeddedRedis) Start() error {
 	// 获取Redis可执行文件路径
 	redisServerPath, err := r.getRedisServerPath()
 	if err != nil {
-		return fmt.Errorf("获取Redis服务器路径失败: %v", err)
+		return err
 	}
+	r.serverPath = redisServerPath
 
 	// 创建Redis配置
-	configPath := filepath.Join(r.dataDir, "redis.conf")
-	if err := r.createRedisConfig(configPath); err != nil {
+	r.configPath = filepath.Join(r.dataDir, "redis.conf")
+	if err := r.createRedisConfig(r.configPath); err != nil {
 		return fmt.Errorf("创建Redis配置失败: %v", err)
 	}
 
-	// 启动Redis服务器
-	r.cmd = exec.CommandContext(r.ctx, redisServerPath, configPath)
-	r.cmd.Dir = r.dataDir
+	cmd, exited, err := r.launch()
+	if err != nil {
+		return err
+	}
 
-	if err := r.cmd.Start(); err != nil {
-		return fmt.Errorf("启动Redis服务器失败: %v", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("内嵌Redis已停止")
 	}
+	r.cmd, r.exited = cmd, exited
+	r.supervised = make(chan struct{})
+	go r.supervise()
+	return nil
+}
 
-	// 等待Redis启动
-	if err := r.waitForRedis(); err != nil {
-		r.Stop()
-		return fmt.Errorf("等待Redis启动失败: %v", err)
+// Stop 停止内嵌Redis服务器，不再自动重启
+func (r *EmbeddedRedis) Stop() error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+	r.stopped = true
+	close(r.stop)
+	supervised := r.supervised
+	r.mu.Unlock()
+
+	// 等待守护协程退出，避免停止时正在重启的进程被遗漏
+	if supervised != nil {
+		<-supervised
 	}
 
-	// 创建Redis客户端
-	r.client = redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("localhost:%d", r.port),
-		Password: "",
-		DB:       0,
-	})
+	r.mu.Lock()
+	cmd, exited := r.cmd, r.exited
+	r.mu.Unlock()
 
-	// 测试连接
-	_, err = r.client.Ping(r.ctx).Result()
-	if err != nil {
-		r.Stop()
-		return fmt.Errorf("Redis连接测试失败: %v", err)
+	if cmd == nil || cmd.Process == nil {
+		return nil
 	}
 
+	// 尝试优雅关闭，让Redis保存数据
+	if runtime.GOOS == "windows" || cmd.Process.Signal(os.Interrupt) != nil {
+		cmd.Process.Kill()
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		// 超时强制终止
+		cmd.Process.Kill()
+		<-exited
+	}
 	return nil
 }
 
-// Stop 停止内嵌Redis服务器
-func (r *EmbeddedRedis) Stop() error {
-	if r.client != nil {
-		r.client.Close()
-	}
+// Addr Redis的监听地址
+func (r *EmbeddedRedis) Addr() string {
+	return fmt.Sprintf("127.0.0.1:%d", r.port)
+}
 
-	if r.cmd != nil && r.cmd.Process != nil {
-		r.cancel()
+// Restarts 进程意外退出后的重启次数
+func (r *EmbeddedRedis) Restarts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.restarts
+}
 
-		// 尝试优雅关闭
-		if err := r.cmd.Process.Signal(os.Interrupt); err != nil {
-			// 如果优雅关闭失败，强制终止
-			r.cmd.Process.Kill()
-		}
+// launch 启动redis-server进程并等待其开始监听，不持有锁，等待期间可以被Stop中断
+func (r *EmbeddedRedis) launch() (*exec.Cmd, chan struct{}, error) {
+	cmd := exec.Command(r.serverPath, r.configPath)
+	cmd.Dir = r.dataDir
 
-		r.cmd.Wait()
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("启动Redis服务器失败: %v", err)
 	}
 
-	return nil
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// 等待Redis启动
+	if err := r.waitForRedis(exited); err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return nil, nil, fmt.Errorf("等待Redis启动失败: %v", err)
+	}
+	return cmd, exited, nil
 }
 
-// GetClient 获取Redis客户端
-func (r *EmbeddedRedis) GetClient() *redis.Client {
-	return r.client
+// supervise 进程意外退出时重启，连续失败时逐步延长重试间隔
+func (r *EmbeddedRedis) supervise() {
+	defer close(r.supervised)
+
+	for {
+		r.mu.Lock()
+		exited := r.exited
+		r.mu.Unlock()
+
+		select {
+		case <-r.stop:
+			return
+		case <-exited:
+		}
+
+		r.logger.Errorf("内嵌Redis进程意外退出，准备重启")
+		backoff := time.Second
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(backoff):
+			}
+
+			cmd, exited, err := r.launch()
+			if err == nil {
+				r.mu.Lock()
+				stopped := r.stopped
+				if !stopped {
+					r.cmd, r.exited = cmd, exited
+					r.restarts++
+				}
+				r.mu.Unlock()
+
+				if stopped {
+					cmd.Process.Kill()
+					<-exited
+					return
+				}
+				r.logger.Infof("内嵌Redis已重启，监听端口: %d", r.port)
+				break
+			}
+			select {
+			case <-r.stop:
+				return // 启动过程被Stop中断
+			default:
+			}
+			r.logger.Errorf("重启内嵌Redis失败: %v", err)
+			if backoff *= 2; backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}
 }
 
 // isPortAvailable 检查端口是否可用
 func (r *EmbeddedRedis) isPortAvailable(port int) bool {
-	address := fmt.Sprintf("localhost:%d", port)
+	address := fmt.Sprintf("127.0.0.1:%d", port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return false
@@ -156,26 +272,31 @@ func (r *EmbeddedRedis) getRedisServerPath() (string, error) {
 	}
 
 	// 在相对路径下查找
-	relativePath := filepath.Join("redis", redisServerName)
-	if _, err := os.Stat(relativePath); err == nil {
-		return relativePath, nil
+	relativePath, err := filepath.Abs(filepath.Join("redis", redisServerName))
+	if err == nil {
+		if _, err := os.Stat(relativePath); err == nil {
+			return relativePath, nil
+		}
 	}
 
-	return "", fmt.Errorf("未找到Redis服务器可执行文件")
+	return "", ErrServerNotFound
 }
 
 // createRedisConfig 创建Redis配置文件
+//
+// 内嵌Redis是广播器的持久存储，保存离线消息、计数器、历史消息和投递状态，
+// 因此使用 noeviction：内存达到maxmemory时写入返回错误，而不是静默淘汰这些数据
 func (r *EmbeddedRedis) createRedisConfig(configPath string) error {
 	config := fmt.Sprintf(`# Redis内嵌配置
 port %d
 bind 127.0.0.1
-dir %s
+dir "%s"
 dbfilename dump.rdb
 save 900 1
 save 300 10
 save 60 10000
-maxmemory 128mb
-maxmemory-policy allkeys-lru
+maxmemory %s
+maxmemory-policy noeviction
 appendonly yes
 appendfilename "appendonly.aof"
 appendfsync everysec
@@ -187,193 +308,28 @@ logfile ""
 timeout 0
 # 启用键过期事件通知
 notify-keyspace-events Ex
-`, r.port, r.dataDir)
+`, r.port, filepath.ToSlash(r.dataDir), r.maxMemory)
 
 	return os.WriteFile(configPath, []byte(config), 0644)
 }
 
-// waitForRedis 等待Redis启动
-func (r *EmbeddedRedis) waitForRedis() error {
-	for i := 0; i < 30; i++ { // 最多等待30秒
-		if r.isPortAvailable(r.port) {
-			time.Sleep(1 * time.Second)
-			continue
+// waitForRedis 等待Redis开始监听，进程提前退出或内嵌Redis被停止时立即返回
+func (r *EmbeddedRedis) waitForRedis(exited <-chan struct{}) error {
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", r.Addr(), time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
 		}
 
-		// 端口被占用说明Redis已启动
-		time.Sleep(500 * time.Millisecond) // 再等待一点确保完全启动
-		return nil
+		select {
+		case <-exited:
+			return fmt.Errorf("Redis进程已退出")
+		case <-r.stop:
+			return fmt.Errorf("内嵌Redis已停止")
+		case <-time.After(200 * time.Millisecond):
+		}
 	}
 	return fmt.Errorf("Redis启动超时")
 }
-
-// MessageStoreInterface 消息存储接口
-type MessageStoreInterface interface {
-	StoreMessage(messageID string, message []byte, ttl time.Duration) error
-	GetMessage(messageID string) ([]byte, error)
-	DeleteMessage(messageID string) error
-	SetMessageStatus(messageID, status string, ttl time.Duration) error
-	GetMessageStatus(messageID string) (string, error)
-	IncrementCounter(key string) (int64, error)
-	GetStats() (map[string]interface{}, error)
-}
-
-// MessageStore Redis消息存储接口
-type MessageStore struct {
-	client *redis.Client
-	ctx    context.Context
-}
-
-// NewMessageStore 创建消息存储实例
-func NewMessageStore(client *redis.Client) MessageStoreInterface {
-	return &MessageStore{
-		client: client,
-		ctx:    context.Background(),
-	}
-}
-
-// StoreMessage 存储消息
-func (ms *MessageStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return ms.client.Set(ms.ctx, key, message, ttl).Err()
-}
-
-// GetMessage 获取消息
-func (ms *MessageStore) GetMessage(messageID string) ([]byte, error) {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return ms.client.Get(ms.ctx, key).Bytes()
-}
-
-// DeleteMessage 删除消息
-func (ms *MessageStore) DeleteMessage(messageID string) error {
-	key := fmt.Sprintf("msg:%s", messageID)
-	return ms.client.Del(ms.ctx, key).Err()
-}
-
-// SetMessageStatus 设置消息状态
-func (ms *MessageStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
-	key := fmt.Sprintf("status:%s", messageID)
-	return ms.client.Set(ms.ctx, key, status, ttl).Err()
-}
-
-// GetMessageStatus 获取消息状态
-func (ms *MessageStore) GetMessageStatus(messageID string) (string, error) {
-	key := fmt.Sprintf("status:%s", messageID)
-	return ms.client.Get(ms.ctx, key).Result()
-}
-
-// IncrementCounter 递增计数器
-func (ms *MessageStore) IncrementCounter(key string) (int64, error) {
-	return ms.client.Incr(ms.ctx, key).Result()
-}
-
-// GetStats 获取Redis统计信息
-func (ms *MessageStore) GetStats() (map[string]interface{}, error) {
-	info, err := ms.client.Info(ms.ctx, "memory", "keyspace", "stats").Result()
-	if err != nil {
-		return nil, err
-	}
-
-	stats := make(map[string]interface{})
-	stats["redis_info"] = info
-
-	// 获取消息相关的键数量
-	msgKeys, err := ms.client.Keys(ms.ctx, "msg:*").Result()
-	if err == nil {
-		stats["stored_messages"] = len(msgKeys)
-	}
-
-	statusKeys, err := ms.client.Keys(ms.ctx, "status:*").Result()
-	if err == nil {
-		stats["message_statuses"] = len(statusKeys)
-	}
-
-	return stats, nil
-}
-
-// MemoryStore 内存消息存储（降级方案）
-type MemoryStore struct {
-	messages map[string][]byte
-	statuses map[string]string
-	counters map[string]int64
-	mutex    sync.RWMutex
-}
-
-// NewMemoryStore 创建内存存储实例
-func NewMemoryStore() MessageStoreInterface {
-	return &MemoryStore{
-		messages: make(map[string][]byte),
-		statuses: make(map[string]string),
-		counters: make(map[string]int64),
-	}
-}
-
-// StoreMessage 存储消息
-func (ms *MemoryStore) StoreMessage(messageID string, message []byte, ttl time.Duration) error {
-	ms.mutex.Lock()
-	defer ms.mutex.Unlock()
-	ms.messages[messageID] = message
-
-	// 内存模式不实现TTL，仅简单存储
-	return nil
-}
-
-// GetMessage 获取消息
-func (ms *MemoryStore) GetMessage(messageID string) ([]byte, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-
-	if msg, exists := ms.messages[messageID]; exists {
-		return msg, nil
-	}
-	return nil, fmt.Errorf("消息不存在")
-}
-
-// DeleteMessage 删除消息
-func (ms *MemoryStore) DeleteMessage(messageID string) error {
-	ms.mutex.Lock()
-	defer ms.mutex.Unlock()
-	delete(ms.messages, messageID)
-	return nil
-}
-
-// SetMessageStatus 设置消息状态
-func (ms *MemoryStore) SetMessageStatus(messageID, status string, ttl time.Duration) error {
-	ms.mutex.Lock()
-	defer ms.mutex.Unlock()
-	ms.statuses[messageID] = status
-	return nil
-}
-
-// GetMessageStatus 获取消息状态
-func (ms *MemoryStore) GetMessageStatus(messageID string) (string, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-
-	if status, exists := ms.statuses[messageID]; exists {
-		return status, nil
-	}
-	return "", fmt.Errorf("状态不存在")
-}
-
-// IncrementCounter 递增计数器
-func (ms *MemoryStore) IncrementCounter(key string) (int64, error) {
-	ms.mutex.Lock()
-	defer ms.mutex.Unlock()
-	ms.counters[key]++
-	return ms.counters[key], nil
-}
-
-// GetStats 获取统计信息
-func (ms *MemoryStore) GetStats() (map[string]interface{}, error) {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-
-	stats := make(map[string]interface{})
-	stats["type"] = "memory"
-	stats["stored_messages"] = len(ms.messages)
-	stats["message_statuses"] = len(ms.statuses)
-	stats["counters"] = len(ms.counters)
-
-	return stats, nil
-}
@@ -2,11 +2,20 @@ package router
 
 import (
 	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
 	"GRUniChat-Broadcaster/pkg/logger"
 	"GRUniChat-Broadcaster/pkg/utils"
 	"sync"
 )
 
+// Target 路由目标
+type Target struct {
+	ServerID  string                 // 目标服务器ID
+	Transform *config.Transform      // 产生该目标的群组/规则上配置的转换规则
+	Group     *config.BroadcastGroup // 产生该目标的群组，由规则产生时为nil
+	Online    bool                   // 目标当前是否已连接
+}
+
 // Router 消息路由器
 type Router struct {
 	config      *config.Config
@@ -31,7 +40,10 @@ func (r *Router) SetHotReloader(hr *config.HotReloader) {
 }
 
 // GetTargets 获取消息目标列表
-func (r *Router) GetTargets(fromServer string, connectedServers []string) []string {
+//
+// 结果包含群组和规则中显式列出但当前未连接的成员（Online为false），
+// 由调用方决定是否为其缓存离线消息；"*" 通配符只展开为已连接的客户端。
+func (r *Router) GetTargets(msg *message.Message, connectedServers []string) []Target {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -39,42 +51,101 @@ func (r *Router) GetTargets(fromServer string, connectedServers []string) []stri
 	if r.hotReloader != nil {
 		if isPaused, reason := r.hotReloader.IsRoutingPaused(); isPaused {
 			r.logger.Infof("路由已暂停，跳过消息路由: %s", reason)
-			return []string{} // 返回空列表，不路由任何消息
+			return []Target{} // 返回空列表，不路由任何消息
 		}
 	}
 
-	r.logger.Debugf("路由查询: from=%s, connected=%v", fromServer, connectedServers)
+	fromServer := msg.From
+	r.logger.Debugf("路由查询: from=%s, type=%s, connected=%v", fromServer, msg.Type, connectedServers)
 
-	// 优先检查groups配置
-	for _, group := range r.config.Groups {
+	var targets []Target
+
+	// 合并发送者所在的所有启用群组
+	for i := range r.config.Groups {
+		group := &r.config.Groups[i]
+		if !group.Enabled || !acceptsType(group.MessageTypes, msg.Type) {
+			continue
+		}
 		if utils.Contains(group.Members, fromServer) {
-			targets := r.filterConnectedServers(utils.RemoveExcept(group.Members, fromServer), connectedServers)
-			r.logger.Debugf("群组路由结果: %v", targets)
-			return targets
+			servers := utils.RemoveExcept(group.Members, fromServer)
+			targets = append(targets, newTargets(servers, connectedServers, group.Transform, group)...)
+			r.logger.Debugf("群组 '%s' 匹配，添加目标: %v", group.Name, servers)
 		}
 	}
 
-	// 回退到rules配置
-	var targets []string
+	// 再合并匹配的rules配置
 	for _, rule := range r.config.Rules {
-		if !rule.Enabled {
+		if !rule.Enabled || !acceptsType(rule.MessageTypes, msg.Type) {
 			continue
 		}
 
 		// 检查是否匹配来源
 		if utils.MatchesAny(fromServer, rule.FromSources) {
 			ruleTargets := r.resolveTargets(rule.ToTargets, fromServer, connectedServers)
-			targets = append(targets, ruleTargets...)
+			targets = append(targets, newTargets(ruleTargets, connectedServers, rule.Transform, nil)...)
 			r.logger.Debugf("规则 '%s' 匹配，添加目标: %v", rule.Name, ruleTargets)
 		}
 	}
 
-	// 去重
-	targets = utils.RemoveDuplicates(targets)
-	r.logger.Debugf("最终路由结果: %v", targets)
+	// 去重：按配置顺序（群组在前、规则在后）保留首次匹配的目标
+	targets = removeDuplicateTargets(targets)
+	r.logger.Debugf("最终路由结果: %v", TargetIDs(targets))
+	return targets
+}
+
+// acceptsType 检查消息类型是否在允许列表中，空列表表示允许所有类型
+func acceptsType(messageTypes []string, msgType string) bool {
+	return len(messageTypes) == 0 || utils.Contains(messageTypes, msgType)
+}
+
+// TargetIDs 提取目标服务器ID列表
+func TargetIDs(targets []Target) []string {
+	ids := make([]string, 0, len(targets))
+	for _, target := range targets {
+		ids = append(ids, target.ServerID)
+	}
+	return ids
+}
+
+// FindTarget 在目标列表中查找指定服务器
+func FindTarget(targets []Target, serverID string) (Target, bool) {
+	for _, target := range targets {
+		if target.ServerID == serverID {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// newTargets 为服务器列表附加同一个来源群组和转换规则，并标记在线状态
+func newTargets(servers, connectedServers []string, transform *config.Transform, group *config.BroadcastGroup) []Target {
+	targets := make([]Target, 0, len(servers))
+	for _, server := range servers {
+		targets = append(targets, Target{
+			ServerID:  server,
+			Transform: transform,
+			Group:     group,
+			Online:    utils.Contains(connectedServers, server),
+		})
+	}
 	return targets
 }
 
+// removeDuplicateTargets 按服务器ID去重，保留首次出现的目标
+func removeDuplicateTargets(targets []Target) []Target {
+	seen := make(map[string]bool)
+	result := []Target{}
+
+	for _, target := range targets {
+		if !seen[target.ServerID] {
+			seen[target.ServerID] = true
+			result = append(result, target)
+		}
+	}
+
+	return result
+}
+
 // resolveTargets 解析目标列表，处理通配符
 func (r *Router) resolveTargets(toTargets []string, fromServer string, connectedServers []string) []string {
 	var resolved []string
@@ -92,19 +163,7 @@ func (r *Router) resolveTargets(toTargets []string, fromServer string, connected
 		}
 	}
 
-	// 只返回已连接的服务器
-	return r.filterConnectedServers(resolved, connectedServers)
-}
-
-// filterConnectedServers 过滤出已连接的服务器
-func (r *Router) filterConnectedServers(targets []string, connectedServers []string) []string {
-	var filtered []string
-	for _, target := range targets {
-		if utils.Contains(connectedServers, target) {
-			filtered = append(filtered, target)
-		}
-	}
-	return filtered
+	return resolved
 }
 
 // IsValidRoute 检查路由是否有效
@@ -114,6 +173,9 @@ func (r *Router) IsValidRoute(fromServer, toServer string) bool {
 
 	// 检查groups
 	for _, group := range r.config.Groups {
+		if !group.Enabled {
+			continue
+		}
 		if utils.Contains(group.Members, fromServer) && utils.Contains(group.Members, toServer) {
 			return true
 		}
@@ -134,6 +196,71 @@ func (r *Router) IsValidRoute(fromServer, toServer string) bool {
 	return false
 }
 
+// GroupRoute 群组路由表条目
+type GroupRoute struct {
+	Name         string          `json:"name"`
+	Enabled      bool            `json:"enabled"`
+	MessageTypes []string        `json:"messageTypes"`
+	Members      map[string]bool `json:"members"` // 成员 -> 是否在线
+}
+
+// RuleRoute 规则路由表条目
+type RuleRoute struct {
+	Name         string   `json:"name"`
+	Enabled      bool     `json:"enabled"`
+	FromSources  []string `json:"fromSources"`
+	ToTargets    []string `json:"toTargets"`
+	MessageTypes []string `json:"messageTypes"`
+}
+
+// GetRoutingTable 获取当前生效的路由表，effective为每个已知服务器按消息类型计算出的目标
+func (r *Router) GetRoutingTable(connectedServers []string) map[string]interface{} {
+	r.mu.RLock()
+	groups := make([]GroupRoute, 0, len(r.config.Groups))
+	servers := append([]string{}, connectedServers...)
+	for _, group := range r.config.Groups {
+		members := make(map[string]bool, len(group.Members))
+		for _, member := range group.Members {
+			members[member] = utils.Contains(connectedServers, member)
+			servers = append(servers, member)
+		}
+		groups = append(groups, GroupRoute{
+			Name:         group.Name,
+			Enabled:      group.Enabled,
+			MessageTypes: group.MessageTypes,
+			Members:      members,
+		})
+	}
+
+	rules := make([]RuleRoute, 0, len(r.config.Rules))
+	for _, rule := range r.config.Rules {
+		rules = append(rules, RuleRoute{
+			Name:         rule.Name,
+			Enabled:      rule.Enabled,
+			FromSources:  rule.FromSources,
+			ToTargets:    rule.ToTargets,
+			MessageTypes: rule.MessageTypes,
+		})
+	}
+	r.mu.RUnlock()
+
+	effective := make(map[string]map[string][]string)
+	for _, server := range utils.RemoveDuplicates(servers) {
+		byType := make(map[string][]string)
+		for _, msgType := range []string{"chat", "event", "command"} {
+			targets := r.GetTargets(&message.Message{From: server, Type: msgType}, connectedServers)
+			byType[msgType] = TargetIDs(targets)
+		}
+		effective[server] = byType
+	}
+
+	return map[string]interface{}{
+		"groups":    groups,
+		"rules":     rules,
+		"effective": effective,
+	}
+}
+
 // GetRouteInfo 获取路由信息用于调试
 func (r *Router) GetRouteInfo() map[string]interface{} {
 	r.mu.RLock()
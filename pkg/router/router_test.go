@@ -0,0 +1,139 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"GRUniChat-Broadcaster/internal/config"
+	"GRUniChat-Broadcaster/internal/message"
+)
+
+// nopLogger 丢弃所有日志
+type nopLogger struct{}
+
+func (nopLogger) Info(v ...interface{})                  {}
+func (nopLogger) Infof(format string, v ...interface{})  {}
+func (nopLogger) Error(v ...interface{})                 {}
+func (nopLogger) Errorf(format string, v ...interface{}) {}
+func (nopLogger) Debug(v ...interface{})                 {}
+func (nopLogger) Debugf(format string, v ...interface{}) {}
+
+// routeCase 单个路由测试用例
+type routeCase struct {
+	name string
+	from string
+	typ  string
+	want []string
+}
+
+// runRouteCases 按配置创建路由器并逐个检查目标列表
+func runRouteCases(t *testing.T, cfg *config.Config, connected []string, cases []routeCase) {
+	t.Helper()
+	r := NewRouter(cfg, nopLogger{})
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TargetIDs(r.GetTargets(&message.Message{From: tt.from, Type: tt.typ}, connected))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTargets(from=%s, type=%s) = %v, 期望 %v", tt.from, tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTargetsMessageTypes(t *testing.T) {
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "聊天", Members: []string{"survival", "qq_bot"}, MessageTypes: []string{"chat"}, Enabled: true},
+			{Name: "全部类型", Members: []string{"survival", "creative"}, Enabled: true},
+		},
+		Rules: []config.BroadcastRule{
+			{Name: "事件转发", FromSources: []string{"survival"}, ToTargets: []string{"discord"}, MessageTypes: []string{"event", "command"}, Enabled: true},
+			{Name: "全部转发", FromSources: []string{"creative"}, ToTargets: []string{"logger"}, Enabled: true},
+		},
+	}
+	runRouteCases(t, cfg, nil, []routeCase{
+		{"群组和规则都限制类型时只有chat进入聊天群组", "survival", "chat", []string{"qq_bot", "creative"}},
+		{"event跳过聊天群组并匹配事件规则", "survival", "event", []string{"creative", "discord"}},
+		{"未列出的类型只走不限类型的群组", "survival", "status", []string{"creative"}},
+		{"不限类型的群组和规则接受任意类型", "creative", "status", []string{"survival", "logger"}},
+		{"不在任何群组的来源没有目标", "qq_bot", "event", []string{}},
+	})
+}
+
+func TestAcceptsType(t *testing.T) {
+	tests := []struct {
+		types []string
+		typ   string
+		want  bool
+	}{
+		{nil, "chat", true},
+		{[]string{}, "event", true},
+		{[]string{"chat"}, "chat", true},
+		{[]string{"chat", "event"}, "command", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsType(tt.types, tt.typ); got != tt.want {
+			t.Errorf("acceptsType(%v, %s) = %v, 期望 %v", tt.types, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestGetTargetsGroupsAndRules(t *testing.T) {
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "生存服", Members: []string{"survival", "qq_bot", "discord"}, Enabled: true},
+			{Name: "已停用", Members: []string{"survival", "creative"}, Enabled: false},
+			{Name: "建筑服", Members: []string{"creative", "survival", "web_panel"}, Enabled: true},
+		},
+		Rules: []config.BroadcastRule{
+			{Name: "重复目标", FromSources: []string{"survival"}, ToTargets: []string{"qq_bot", "archive"}, Enabled: true},
+			{Name: "已停用规则", FromSources: []string{"survival"}, ToTargets: []string{"disabled_target"}, Enabled: false},
+			{Name: "通配来源", FromSources: []string{"*"}, ToTargets: []string{"*"}, Enabled: true},
+		},
+	}
+	connected := []string{"survival", "creative", "monitor"}
+
+	runRouteCases(t, cfg, connected, []routeCase{
+		// 按配置顺序合并：群组在前、规则在后，重复的目标只保留第一次出现
+		{"合并多个群组和规则并去重", "survival", "chat", []string{"qq_bot", "discord", "creative", "web_panel", "archive", "monitor"}},
+		{"停用的群组不产生目标", "creative", "chat", []string{"survival", "web_panel", "monitor"}},
+		{"通配符只展开为已连接的客户端", "monitor", "chat", []string{"survival", "creative"}},
+	})
+}
+
+func TestGetTargetsKeepsFirstMatch(t *testing.T) {
+	chatTransform := &config.Transform{}
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "A", Members: []string{"survival", "qq_bot"}, Enabled: true, Transform: chatTransform},
+			{Name: "B", Members: []string{"survival", "qq_bot"}, Enabled: true},
+		},
+		Rules: []config.BroadcastRule{
+			{Name: "规则", FromSources: []string{"survival"}, ToTargets: []string{"qq_bot"}, Enabled: true},
+		},
+	}
+	r := NewRouter(cfg, nopLogger{})
+
+	targets := r.GetTargets(&message.Message{From: "survival", Type: "chat"}, []string{"qq_bot"})
+	if len(targets) != 1 {
+		t.Fatalf("目标 = %v, 期望去重后只剩qq_bot", TargetIDs(targets))
+	}
+	target := targets[0]
+	if target.Group == nil || target.Group.Name != "A" || target.Transform != chatTransform || !target.Online {
+		t.Errorf("目标 = %+v, 期望保留第一个群组A的群组和转换规则并标记在线", target)
+	}
+}
+
+func TestGetTargetsOfflineMembers(t *testing.T) {
+	cfg := &config.Config{
+		Groups: []config.BroadcastGroup{
+			{Name: "互通", Members: []string{"survival", "qq_bot"}, Enabled: true},
+		},
+	}
+	r := NewRouter(cfg, nopLogger{})
+
+	targets := r.GetTargets(&message.Message{From: "survival", Type: "chat"}, []string{"survival"})
+	if len(targets) != 1 || targets[0].ServerID != "qq_bot" || targets[0].Online {
+		t.Errorf("目标 = %+v, 期望包含离线的qq_bot", targets)
+	}
+}